@@ -0,0 +1,228 @@
+package twitchwh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the cookie that binds the OAuth state to the browser that started the flow
+const stateCookie = "twitchwh_oauth_state"
+const defaultStateTTL = 10 * time.Minute
+
+// AuthorizerConfig is used to configure a new Authorizer
+type AuthorizerConfig struct {
+	// URL that Twitch redirects the user to after authorizing, which must be served by Authorizer.Callback.
+	// Must match one of the OAuth Redirect URLs of your Twitch application.
+	RedirectURL string
+	// Scopes to request, eg. "channel:read:subscriptions". See [RequiredScopes]
+	Scopes []string
+	// Make the user authorize the app again, even if they already did
+	ForceVerify bool
+	// Returns the subscriptions to create for a user after they authorized the app. Optional.
+	// They are created concurrently before OnAuthorized is called, which takes up to ClientConfig.VerificationTimeout.
+	Subscriptions func(userID string) []SubscriptionSpec
+	// Called after the user authorized the app and the subscriptions were created. The user access token has been
+	// added to the client with [Client.SetUserToken]. err joins the errors of any subscriptions that could not be created.
+	// Should write the response, eg. a redirect to your app. Defaults to responding with 200 OK.
+	OnAuthorized func(w http.ResponseWriter, r *http.Request, token UserToken, subscriptions []Subscription, err error)
+	// Called when the authorization fails, eg. when the user denied it or the state did not match ([AuthorizationError]),
+	// or the code could not be exchanged for a token. Should write the response. Defaults to responding with 400 Bad Request.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+	// How long the user has to authorize the app. Defaults to 10 minutes
+	StateTTL time.Duration
+}
+
+// Authorizer implements the OAuth authorization code flow, for users to grant scopes to your app.
+// See: https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#authorization-code-grant-flow
+//
+// Send users to Authorizer.Redirect, and serve Authorizer.Callback at AuthorizerConfig.RedirectURL.
+// The state parameter is bound to the user's browser with a cookie, so no state is kept on the server.
+type Authorizer struct {
+	client *Client
+	config AuthorizerConfig
+}
+
+// NewAuthorizer creates an Authorizer for the authorization code flow. Requires ClientConfig.ClientSecret.
+//
+//	authorizer := client.NewAuthorizer(twitchwh.AuthorizerConfig{
+//		RedirectURL: "https://mydomain.com/auth/callback",
+//		Scopes:      []string{"channel:read:subscriptions"},
+//		Subscriptions: func(userID string) []twitchwh.SubscriptionSpec {
+//			return []twitchwh.SubscriptionSpec{
+//				{Type: "channel.subscribe", Version: "1", Condition: twitchwh.Condition{BroadcasterUserID: userID}},
+//			}
+//		},
+//	})
+//	http.HandleFunc("/auth", authorizer.Redirect)
+//	http.HandleFunc("/auth/callback", authorizer.Callback)
+func (c *Client) NewAuthorizer(config AuthorizerConfig) *Authorizer {
+	if config.StateTTL == 0 {
+		config.StateTTL = defaultStateTTL
+	}
+	return &Authorizer{client: c, config: config}
+}
+
+// Returned to AuthorizerConfig.OnError when Twitch redirects back with an error, or the state does not match.
+type AuthorizationError struct {
+	// OAuth error code, eg. access_denied. invalid_state if the state did not match the cookie
+	Code        string
+	Description string
+}
+
+func (e *AuthorizationError) Error() string {
+	return "Authorization failed: " + e.Code + ": " + e.Description
+}
+
+// Returns the URL of the Twitch page asking the user to authorize the app. Twitch passes state back to the callback.
+func (a *Authorizer) authorizeURL(state string) string {
+	values := url.Values{
+		"client_id":     {a.client.clientID},
+		"redirect_uri":  {a.config.RedirectURL},
+		"response_type": {"code"},
+		"scope":         {strings.Join(a.config.Scopes, " ")},
+		"state":         {state},
+	}
+	if a.config.ForceVerify {
+		values.Set("force_verify", "true")
+	}
+	return a.client.oauthURL + "/authorize?" + values.Encode()
+}
+
+// Redirect sends the user to Twitch to authorize the app. The state is stored in a cookie.
+func (a *Authorizer) Redirect(w http.ResponseWriter, r *http.Request) {
+	state, err := randomState()
+	if err != nil {
+		a.fail(w, r, &InternalError{"Could not generate state", err})
+		return
+	}
+	http.SetCookie(w, a.newStateCookie(state, int(a.config.StateTTL.Seconds())))
+	http.Redirect(w, r, a.authorizeURL(state), http.StatusFound)
+}
+
+// Callback handles the redirect back from Twitch. It checks the state, exchanges the code for a user access token,
+// adds the token to the client, and creates the subscriptions from AuthorizerConfig.Subscriptions.
+func (a *Authorizer) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// Clear the state, it is only valid once
+	http.SetCookie(w, a.newStateCookie("", -1))
+
+	cookie, err := r.Cookie(stateCookie)
+	state := query.Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		a.fail(w, r, &AuthorizationError{Code: "invalid_state", Description: "State does not match"})
+		return
+	}
+	if query.Has("error") {
+		a.fail(w, r, &AuthorizationError{Code: query.Get("error"), Description: query.Get("error_description")})
+		return
+	}
+
+	ctx := r.Context()
+	c := a.client
+	exchanged, err := c.requestUserToken(ctx, url.Values{
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+		"code":          {query.Get("code")},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {a.config.RedirectURL},
+	})
+	if err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	token, err := c.validateUserToken(ctx, exchanged.AccessToken)
+	if err != nil {
+		a.fail(w, r, err)
+		return
+	}
+	token.RefreshToken = exchanged.RefreshToken
+	c.SetUserToken(token)
+	c.logger.Info("User authorized the app", "user_id", token.UserID, "scopes", token.Scopes)
+
+	subscriptions, err := a.createSubscriptions(ctx, token.UserID)
+	if a.config.OnAuthorized != nil {
+		a.config.OnAuthorized(w, r, token, subscriptions, err)
+		return
+	}
+	w.Write([]byte("Authorized"))
+}
+
+// Creates the subscriptions from AuthorizerConfig.Subscriptions for the user. They are created concurrently, so that
+// the callback waits for the slowest verification instead of every verification in turn.
+// Returns the created subscriptions in the order of AuthorizerConfig.Subscriptions, and the joined errors of the others.
+func (a *Authorizer) createSubscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	if a.config.Subscriptions == nil {
+		return nil, nil
+	}
+	c := a.client
+	specs := a.config.Subscriptions(userID)
+	created := make([]Subscription, len(specs))
+	errs := make([]error, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		if spec.Callback == "" {
+			spec.Callback = c.webhookURL
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.retryUnauthorized(ctx, func() (err error) {
+				created[i], err = c.addWebhookSubscription(ctx, spec.Callback, spec.Type, spec.Version, spec.Condition)
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+
+	var subscriptions []Subscription
+	for i, err := range errs {
+		if err == nil {
+			subscriptions = append(subscriptions, created[i])
+		}
+	}
+	return subscriptions, errors.Join(errs...)
+}
+
+// Returns the cookie holding the state. Browsers only replace a cookie with the same name, domain and path,
+// so the cookie is always scoped to the path of the RedirectURL, no matter which path Redirect is served at.
+func (a *Authorizer) newStateCookie(state string, maxAge int) *http.Cookie {
+	path := "/"
+	if redirect, err := url.Parse(a.config.RedirectURL); err == nil && redirect.Path != "" {
+		path = redirect.Path
+	}
+	return &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     path,
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(a.config.RedirectURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (a *Authorizer) fail(w http.ResponseWriter, r *http.Request, err error) {
+	a.client.logger.Warn("Authorization failed", "error", err)
+	if a.config.OnError != nil {
+		a.config.OnError(w, r, err)
+		return
+	}
+	http.Error(w, "Authorization failed", http.StatusBadRequest)
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,17 +1,22 @@
-// Package twitchwh is a library for interacting with Twitch EventSub over the Webhook transport.
+// Package twitchwh is a library for interacting with Twitch EventSub over the Webhook and WebSocket transports.
 // It allows you to assign event handlers to specific events.
 //
 // To get started, create a new client using the New function. Then, assign an event handler using the On<EventType> fields.
-// Finally, setup the HTTP handler for your application using the Handler function.
+// Finally, setup the HTTP handler for your application using the Handler function,
+// or connect over the WebSocket transport using the ConnectWebSocket function.
 package twitchwh
 
 import (
+	"context"
 	"encoding/json"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ClientConfig is used to configure a new Client
@@ -19,86 +24,401 @@ type ClientConfig struct {
 	// Client ID of your Twitch application
 	ClientID string
 	// Client Secret generated for your Twitch application. !! THIS IS NOT YOUR WEBHOOK SECRET !!
+	// Not needed if TokenProvider is set, unless the client refreshes user access tokens.
 	ClientSecret string
+	// Supplies the app access token used for Helix requests.
+	// Defaults to ClientCredentials, which generates tokens using ClientID and ClientSecret.
+	TokenProvider TokenProvider
 	// Webhook secret used to verify events. This should be a random string between 10-100 characters
 	WebhookSecret string
+	// Secrets that subscriptions were created with before WebhookSecret was changed, which are still accepted
+	// by Client.Handler. Useful when the client is restarted during a rotation, see [Client.RotateWebhookSecret].
+	PreviousWebhookSecrets []string
 	// Full EventSub URL path, eg: https://mydomain.com/eventsub
 	WebhookURL string
-	// Log output
+	// Base URL of the Helix API. Defaults to https://api.twitch.tv/helix
+	//
+	// Useful for testing against a mock server, eg. the Twitch CLI mock API or an httptest.Server.
+	HelixURL string
+	// Base URL of the Twitch OAuth API used to generate and validate tokens. Defaults to https://id.twitch.tv/oauth2
+	OAuthURL string
+	// HTTP client used for all requests. Defaults to a new http.Client.
+	// Set its Transport to intercept requests in tests.
+	HTTPClient *http.Client
+	// WebSocket URL used by ConnectWebSocket. Defaults to wss://eventsub.wss.twitch.tv/ws
+	WebSocketURL string
+	// Store used to keep track of handled message IDs. Defaults to an in-memory store that remembers message IDs for
+	// twice MaxMessageAge, or forever if the MaxMessageAge check is disabled. Use a shared store to dedupe events across
+	// several replicas, with an expiry of at least twice MaxMessageAge.
+	DedupStore DedupStore
+	// Requests with a timestamp older than this (or this far in the future) are rejected to prevent replay attacks.
+	// Defaults to 10 minutes, as recommended by Twitch. Set to a negative value to disable the check.
+	MaxMessageAge time.Duration
+	// How long AddSubscription waits for Twitch to send the verification request. Defaults to 10 seconds
+	VerificationTimeout time.Duration
+	// Returns the current time. Used to check message timestamps. Defaults to time.Now
+	Clock func() time.Time
+	// How many times a Helix request is retried after a 429 response, or a 5xx response or network error for
+	// GET and DELETE requests. Defaults to 3. Set to a negative value to disable retries.
+	MaxRetries int
+	// Base delay between retries. Doubled after every attempt, with jitter. Defaults to 500 milliseconds
+	RetryBackoff time.Duration
+	// Structured logger for the client. Routine messages are logged at the debug level, failures the client
+	// recovers from (eg. invalid signatures, retried requests) at the warn level, and failures it can not at the error level.
+	// Defaults to the default slog logger, with only warnings and errors.
+	Logger *slog.Logger
+	// Log everything to stdout. Ignored if Logger is set
 	Debug bool
+	// Receives measurements of webhook requests, handlers, and Helix requests. See [PrometheusMetrics]
+	Metrics Metrics
+	// Used to trace webhook requests, handlers, and Helix and OAuth requests. Defaults to the global OpenTelemetry TracerProvider
+	TracerProvider trace.TracerProvider
+	// Limits how many handlers run at once. By default, every notification is handled in a new goroutine
+	Dispatch DispatchConfig
+	// Revoke the app access token in Client.Close. Only use this if the token is not shared with other processes
+	RevokeTokenOnClose bool
 }
 
+// Twitch recommends rejecting messages older than 10 minutes
+const defaultMaxMessageAge = 10 * time.Minute
+const defaultVerificationTimeout = 10 * time.Second
+
 type Client struct {
-	clientID      string
-	clientSecret  string
-	token         string
-	webhookSecret string
-	webhookURL    string
-	debug         bool
-
-	logger        *log.Logger
-	httpClient    *http.Client
-	handledEvents []string
-	// Client.Handler sends verified IDs to this channel to be read in Client.AddSubscription
-	verifiedSubscriptions chan string
+	clientID     string
+	clientSecret string
+	tokens       TokenProvider
+	webhookURL   string
+	webSocketURL string
+	helixURL     string
+	oauthURL     string
+
+	logger     *slog.Logger
+	metrics    Metrics
+	tracer     trace.Tracer
+	dispatcher *dispatcher
+	httpClient *http.Client
+	dedupStore DedupStore
+	// Maximum age of webhook requests, negative if disabled
+	maxMessageAge time.Duration
+	clock         func() time.Time
+	// Client.Handler marks subscriptions as verified here, which Client.AddSubscription waits for
+	verifications       map[string]*verification
+	verificationsMu     sync.Mutex
+	verificationTimeout time.Duration
+	rateLimiter         rateLimiter
+	quota               quotaTracker
+	webhookSecrets      webhookSecrets
+	// User access tokens by user ID
+	userTokens   map[string]UserToken
+	userTokensMu sync.Mutex
+	// Retries of Helix requests, negative if disabled
+	maxRetries   int
+	retryBackoff time.Duration
+	sleep        func(context.Context, time.Duration) error
+	// Cancelled by Client.Close to stop the token validation loop and WebSocket connections
+	background         context.Context
+	stopBackground     context.CancelFunc
+	closeOnce          sync.Once
+	revokeTokenOnClose bool
+	// Whether Client.Close has revoked the app access token
+	revoked  bool
+	revokeMu sync.Mutex
 
 	// Fired whenever a subscription is revoked.
 	// Check Subscription.Status for the reason.
 	OnRevocation func(Subscription)
-	handlers     map[string]func(json.RawMessage)
+	// Fired whenever a webhook request is rejected because its timestamp is too old or too far in the future.
+	OnStaleMessage func(StaleMessage)
+	// Fired whenever a handler registered with OnEvent receives an event body that can not be decoded.
+	// The handler is not called for that event.
+	OnDecodeError func(*EventDecodeError)
+	// Fired whenever a handler returns an error or panics (as *HandlerError), and whenever a webhook request
+	// or WebSocket message can not be handled, eg. because of an invalid signature (*InvalidSignatureError)
+	// or an unreadable body (*InternalError). May be called from several goroutines at once.
+	OnError func(error)
+	// Fired whenever a user access token is refreshed. Twitch rotates refresh tokens, so persist the new token here.
+	// Must not call the user token methods of the client.
+	OnUserTokenRefresh func(UserToken)
+
+	// Handlers by event type, replaced rather than modified in place
+	handlers      map[string][]registeredHandler
+	handlersMu    sync.RWMutex
+	nextHandlerID uint64
+	middleware    []Middleware
+}
+
+// Registration is a handler assigned with [Client.On], [OnEvent], or one of their variants. See [Registration.Remove].
+type Registration struct {
+	client *Client
+	event  string
+	id     uint64
+}
+
+type registeredHandler struct {
+	id     uint64
+	handle HandlerFunc
+}
+
+// Remove unassigns the handler. Events that are already being handled are not affected.
+// Removing a handler more than once does nothing.
+func (r *Registration) Remove() {
+	c := r.client
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	handlers := c.handlers[r.event]
+	i := slices.IndexFunc(handlers, func(h registeredHandler) bool { return h.id == r.id })
+	if i < 0 {
+		return
+	}
+	if len(handlers) == 1 {
+		delete(c.handlers, r.event)
+		return
+	}
+	// Copied, since dispatch may be iterating over the old slice
+	c.handlers[r.event] = slices.Delete(slices.Clone(handlers), i, i+1)
+}
+
+// Handlers assigned with Client.OnUnhandled are stored under the empty event type.
+const unhandledEvent = ""
+
+// register assigns the handler to the event type, after any handlers that are already assigned.
+func (c *Client) register(event string, handler HandlerFunc) *Registration {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.nextHandlerID++
+	c.handlers[event] = append(slices.Clip(c.handlers[event]), registeredHandler{c.nextHandlerID, handler})
+	return &Registration{client: c, event: event, id: c.nextHandlerID}
+}
+
+// handlersFor returns the handlers assigned to the event type, or the ones assigned with OnUnhandled if there are none.
+// The returned slice must not be modified.
+func (c *Client) handlersFor(event string) []registeredHandler {
+	c.handlersMu.RLock()
+	defer c.handlersMu.RUnlock()
+	if handlers, ok := c.handlers[event]; ok {
+		return handlers
+	}
+	return c.handlers[unhandledEvent]
 }
 
 // Assign a handler to a particular event type. The handler takes a json.RawMessage that contains the event body.
 // For a list of event types, see [https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/].
-func (c *Client) On(event string, handler func(json.RawMessage)) {
-	c.handlers[event] = handler
+//
+// Several handlers can be assigned to the same event type. They are called one after another, in the order they were assigned.
+// The handler is unassigned with Registration.Remove.
+func (c *Client) On(event string, handler func(json.RawMessage)) *Registration {
+	return c.OnContext(event, func(ctx context.Context, body json.RawMessage) error {
+		handler(body)
+		return nil
+	})
+}
+
+// OnContext is like [Client.On], but the handler also takes a context, and returns an error that is passed to Client.OnError.
+// The context carries the trace span of the event, and is not cancelled when the webhook request completes.
+func (c *Client) OnContext(event string, handler func(ctx context.Context, event json.RawMessage) error) *Registration {
+	return c.register(event, func(ctx context.Context, notification Notification) error {
+		return handler(ctx, notification.Event)
+	})
+}
+
+// OnUnhandled assigns a handler for events that do not have a handler assigned to their type.
+// Without one, those events are only logged.
+//
+//	client.OnUnhandled(func(ctx context.Context, notification twitchwh.Notification) error {
+//		log.Printf("Unhandled %s event", notification.Subscription.Type)
+//		return nil
+//	})
+func (c *Client) OnUnhandled(handler HandlerFunc) *Registration {
+	return c.register(unhandledEvent, handler)
+}
+
+// OnEvent assigns a typed handler to a particular event type. The event body is decoded into T before the handler is called.
+// If the event body can not be decoded, the handler is not called and the error is passed to Client.OnDecodeError.
+//
+// This is a function rather than a method since Go does not allow type parameters on methods.
+//
+//	twitchwh.OnEvent(client, "stream.online", func(event twitchwh.StreamOnlineEvent) {
+//		log.Printf("%s went live!", event.BroadcasterUserLogin)
+//	})
+func OnEvent[T any](c *Client, event string, handler func(T)) *Registration {
+	return OnEventContext(c, event, func(ctx context.Context, decoded T) error {
+		handler(decoded)
+		return nil
+	})
+}
+
+// OnEventContext is like [OnEvent], but the handler also takes a context, and returns an error. See [Client.OnContext].
+func OnEventContext[T any](c *Client, event string, handler func(ctx context.Context, event T) error) *Registration {
+	return c.OnContext(event, func(ctx context.Context, body json.RawMessage) error {
+		var decoded T
+		err := json.Unmarshal(body, &decoded)
+		if err != nil {
+			decodeErr := &EventDecodeError{Type: event, Event: body, OriginalError: err}
+			if c.OnDecodeError != nil {
+				c.OnDecodeError(decodeErr)
+			}
+			return decodeErr
+		}
+		return handler(ctx, decoded)
+	})
 }
 
 // Creates a new client
 func New(config ClientConfig) (*Client, error) {
 	c := &Client{
-		clientID:              config.ClientID,
-		clientSecret:          config.ClientSecret,
-		webhookSecret:         config.WebhookSecret,
-		webhookURL:            config.WebhookURL,
-		logger:                log.New(os.Stdout, "TwitchWH: ", log.Ltime|log.Lmicroseconds),
-		debug:                 config.Debug,
-		httpClient:            &http.Client{},
-		verifiedSubscriptions: make(chan string),
-		handlers:              make(map[string]func(json.RawMessage)),
+		clientID:            config.ClientID,
+		clientSecret:        config.ClientSecret,
+		tokens:              config.TokenProvider,
+		webhookSecrets:      webhookSecrets{current: config.WebhookSecret, previous: config.PreviousWebhookSecrets},
+		webhookURL:          config.WebhookURL,
+		webSocketURL:        config.WebSocketURL,
+		helixURL:            config.HelixURL,
+		oauthURL:            config.OAuthURL,
+		dedupStore:          config.DedupStore,
+		maxMessageAge:       config.MaxMessageAge,
+		clock:               config.Clock,
+		logger:              config.Logger,
+		metrics:             config.Metrics,
+		tracer:              newTracer(config.TracerProvider),
+		httpClient:          config.HTTPClient,
+		verificationTimeout: config.VerificationTimeout,
+		maxRetries:          config.MaxRetries,
+		retryBackoff:        config.RetryBackoff,
+		sleep:               sleepContext,
+		revokeTokenOnClose:  config.RevokeTokenOnClose,
+		userTokens:          make(map[string]UserToken),
+		handlers:            make(map[string][]registeredHandler),
+	}
+
+	if c.helixURL == "" {
+		c.helixURL = helixURL
+	}
+	c.helixURL = strings.TrimSuffix(c.helixURL, "/")
+	if c.oauthURL == "" {
+		c.oauthURL = oauthURL
+	}
+	c.oauthURL = strings.TrimSuffix(c.oauthURL, "/")
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{}
+	}
+	if c.tokens == nil {
+		c.tokens = &ClientCredentials{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			OAuthURL:     c.oauthURL,
+			HTTPClient:   c.httpClient,
+			tracer:       c.tracer,
+		}
+	}
+	if c.webSocketURL == "" {
+		c.webSocketURL = webSocketURL
+	}
+	if c.maxMessageAge == 0 {
+		c.maxMessageAge = defaultMaxMessageAge
+	}
+	if c.verificationTimeout == 0 {
+		c.verificationTimeout = defaultVerificationTimeout
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	}
+	if c.retryBackoff == 0 {
+		c.retryBackoff = defaultRetryBackoff
+	}
+	if c.clock == nil {
+		c.clock = time.Now
+	}
+	if c.dedupStore == nil {
+		c.dedupStore = newDefaultDedupStore(c.maxMessageAge, c.clock)
 	}
 
-	// Disable logging if debug is false
-	if !c.debug {
-		c.logger.SetOutput(io.Discard)
+	if c.logger == nil {
+		c.logger = defaultLogger(config.Debug)
 	}
+	if c.metrics == nil {
+		c.metrics = nopMetrics{}
+	}
+	c.dispatcher = newDispatcher(config.Dispatch, c.dropped)
 
-	c.logger.Println("Generating token")
-	token, err := c.generateToken(c.clientID, c.clientSecret)
+	c.logger.Debug("Fetching token")
+	_, err := c.tokens.Token(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	c.logger.Println("Token generated")
-	c.token = token
-	go func() {
-		for {
-			time.Sleep(1 * time.Hour)
-			valid, err := c.validateToken(c.token)
+	c.logger.Debug("Token fetched")
+	c.background, c.stopBackground = context.WithCancel(context.Background())
+	go c.validateLoop(c.background)
+
+	return c, nil
+}
+
+// validateLoop validates the app access token every hour, as required by Twitch, until ctx is done.
+func (c *Client) validateLoop(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			c.logger.Error("Could not get token", "error", err)
+			continue
+		}
+		valid, err := c.validateToken(ctx, token)
+		if err != nil {
+			c.logger.Error("Could not validate token", "error", err)
+			continue
+		}
+		if !valid {
+			err := c.refreshToken(ctx, token)
 			if err != nil {
-				c.logger.Printf("Could not validate token: %s", err)
-				continue
-			}
-			if !valid {
-				c.logger.Println("Token invalid, generating a new one")
-				token, err := c.generateToken(c.clientID, c.clientSecret)
-				if err != nil {
-					c.logger.Printf("Could not generate token: %s", err)
-					continue
-				}
-				c.token = token
+				c.logger.Error("Could not refresh token", "error", err)
 			}
 		}
-	}()
+	}
+}
 
-	return c, nil
+// Close shuts down the client gracefully:
+//
+//   - The hourly token validation stops, and WebSocket connections are closed.
+//   - Handler rejects new notifications with 503 Service Unavailable, so that Twitch retries them
+//     (eg. on another replica). Verification challenges and revocations are still handled.
+//   - Close waits for queued and running handlers to finish, or for ctx to be done, in which case it returns the error of ctx.
+//   - The app access token is revoked if ClientConfig.RevokeTokenOnClose is set.
+//
+// Close can be called again after it returned an error, eg. to keep waiting for the handlers.
+//
+//	server.Shutdown(ctx)
+//	client.Close(ctx)
+func (c *Client) Close(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		c.logger.Info("Closing client")
+		c.stopBackground()
+	})
+	err := c.dispatcher.close(ctx)
+	if err != nil {
+		c.logger.Warn("Handlers did not finish before close timeout", "error", err)
+		return err
+	}
+	if !c.revokeTokenOnClose {
+		return nil
+	}
+	c.revokeMu.Lock()
+	defer c.revokeMu.Unlock()
+	if c.revoked {
+		return nil
+	}
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.revokeToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	c.revoked = true
+	return nil
 }
@@ -0,0 +1,33 @@
+package twitchwh
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// newTestClient returns a client with the defaults set by New, without generating a token.
+func newTestClient() *Client {
+	return &Client{
+		tokens:              StaticToken("token"),
+		webhookSecrets:      webhookSecrets{current: "supersecretstring"},
+		helixURL:            helixURL,
+		oauthURL:            oauthURL,
+		logger:              discardLogger(),
+		metrics:             nopMetrics{},
+		httpClient:          &http.Client{},
+		dedupStore:          newDefaultDedupStore(defaultMaxMessageAge, time.Now),
+		maxMessageAge:       defaultMaxMessageAge,
+		clock:               time.Now,
+		verificationTimeout: defaultVerificationTimeout,
+		maxRetries:          defaultMaxRetries,
+		retryBackoff:        defaultRetryBackoff,
+		sleep:               sleepContext,
+		userTokens:          make(map[string]UserToken),
+		tracer:              newTracer(nil),
+		dispatcher:          newDispatcher(DispatchConfig{}, nil),
+		background:          context.Background(),
+		stopBackground:      func() {},
+		handlers:            make(map[string][]registeredHandler),
+	}
+}
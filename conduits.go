@@ -0,0 +1,260 @@
+package twitchwh
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+)
+
+// Conduit is a Twitch EventSub conduit. Subscriptions created for a conduit are spread over its shards.
+// See: https://dev.twitch.tv/docs/eventsub/handling-conduit-events/
+type Conduit struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+// ShardStatus is the status of a conduit shard.
+type ShardStatus string
+
+// List of shard statuses
+// See: https://dev.twitch.tv/docs/api/reference/#get-conduit-shards
+const (
+	ShardEnabled                            ShardStatus = "enabled"
+	ShardWebhookCallbackVerificationPending ShardStatus = "webhook_callback_verification_pending"
+	ShardWebhookCallbackVerificationFailed  ShardStatus = "webhook_callback_verification_failed"
+	ShardNotificationFailuresExceeded       ShardStatus = "notification_failures_exceeded"
+	ShardWebSocketDisconnected              ShardStatus = "websocket_disconnected"
+	ShardWebSocketFailedPingPong            ShardStatus = "websocket_failed_ping_pong"
+	ShardWebSocketReceivedInboundTraffic    ShardStatus = "websocket_received_inbound_traffic"
+	ShardWebSocketInternalError             ShardStatus = "websocket_internal_error"
+	ShardWebSocketNetworkTimeout            ShardStatus = "websocket_network_timeout"
+	ShardWebSocketNetworkError              ShardStatus = "websocket_network_error"
+	ShardWebSocketFailedToReconnect         ShardStatus = "websocket_failed_to_reconnect"
+)
+
+// ShardTransport is the transport of a conduit shard.
+// Set Method to "webhook" and fill out Callback and Secret, or set Method to "websocket" and fill out SessionID.
+type ShardTransport struct {
+	// webhook or websocket
+	Method   string `json:"method"`
+	Callback string `json:"callback,omitempty"`
+	// Only used when updating shards, Twitch never returns the secret
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	// Only set for WebSocket shards
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+	// Only set for WebSocket shards
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+}
+
+// Shard is a single shard of a conduit.
+type Shard struct {
+	ID        string         `json:"id"`
+	Status    ShardStatus    `json:"status"`
+	Transport ShardTransport `json:"transport"`
+}
+
+// ShardUpdate assigns a transport to a shard. Used by [Client.UpdateConduitShards].
+type ShardUpdate struct {
+	ID        string         `json:"id"`
+	Transport ShardTransport `json:"transport"`
+}
+
+// WebhookShard returns a ShardUpdate that assigns the client's webhook URL and secret to the shard.
+func (c *Client) WebhookShard(id string) ShardUpdate {
+	return ShardUpdate{
+		ID: id,
+		Transport: ShardTransport{
+			Method:   "webhook",
+			Callback: c.webhookURL,
+			Secret:   c.webhookSecrets.get(),
+		},
+	}
+}
+
+// Shard returns a ShardUpdate that assigns the current WebSocket session to the shard.
+func (ws *WebSocket) Shard(id string) ShardUpdate {
+	return ShardUpdate{
+		ID: id,
+		Transport: ShardTransport{
+			Method:    "websocket",
+			SessionID: ws.SessionID(),
+		},
+	}
+}
+
+// CreateConduit creates a new conduit with the provided number of shards.
+// Shards have to be assigned a transport with [Client.UpdateConduitShards] before they receive any events.
+func (c *Client) CreateConduit(ctx context.Context, shardCount int) (Conduit, error) {
+	var responseBody struct {
+		Data []Conduit `json:"data"`
+	}
+	err := c.retryUnauthorized(ctx, func() error {
+		return c.jsonRequest(ctx, "POST", "/eventsub/conduits", map[string]int{"shard_count": shardCount}, 200, &responseBody)
+	})
+	if err != nil {
+		return Conduit{}, err
+	}
+	if len(responseBody.Data) < 1 {
+		return Conduit{}, &InternalError{"Helix did not return the conduit they were supposed to", nil}
+	}
+	c.logger.Info("Conduit created", "conduit_id", responseBody.Data[0].ID)
+	return responseBody.Data[0], nil
+}
+
+// GetConduits retrieves all conduits owned by the client.
+func (c *Client) GetConduits(ctx context.Context) (conduits []Conduit, err error) {
+	var responseBody struct {
+		Data []Conduit `json:"data"`
+	}
+	err = c.retryUnauthorized(ctx, func() error {
+		return c.jsonRequest(ctx, "GET", "/eventsub/conduits", nil, 200, &responseBody)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return responseBody.Data, nil
+}
+
+// UpdateConduit changes the number of shards of a conduit.
+// Returns [ConduitNotFoundError] if the conduit does not exist.
+func (c *Client) UpdateConduit(ctx context.Context, id string, shardCount int) (Conduit, error) {
+	reqBody := struct {
+		ID         string `json:"id"`
+		ShardCount int    `json:"shard_count"`
+	}{id, shardCount}
+	var responseBody struct {
+		Data []Conduit `json:"data"`
+	}
+	err := c.retryUnauthorized(ctx, func() error {
+		return c.jsonRequest(ctx, "PATCH", "/eventsub/conduits", reqBody, 200, &responseBody)
+	})
+	if err != nil {
+		return Conduit{}, conduitNotFound(err)
+	}
+	if len(responseBody.Data) < 1 {
+		return Conduit{}, &InternalError{"Helix did not return the conduit they were supposed to", nil}
+	}
+	return responseBody.Data[0], nil
+}
+
+// DeleteConduit deletes a conduit. All subscriptions for the conduit are removed by Twitch.
+// Returns [ConduitNotFoundError] if the conduit does not exist.
+func (c *Client) DeleteConduit(ctx context.Context, id string) error {
+	err := c.retryUnauthorized(ctx, func() error {
+		return c.jsonRequest(ctx, "DELETE", "/eventsub/conduits?id="+url.QueryEscape(id), nil, 204, nil)
+	})
+	if err != nil {
+		return conduitNotFound(err)
+	}
+	c.logger.Info("Conduit deleted", "conduit_id", id)
+	return nil
+}
+
+// GetConduitShards retrieves all shards of a conduit.
+// Automatically handles pagination.
+func (c *Client) GetConduitShards(ctx context.Context, conduitID string) (shards []Shard, err error) {
+	return c.fetchConduitShards(ctx, conduitID, "")
+}
+
+// GetConduitShardsByStatus retrieves all shards of a conduit with the provided status.
+// Automatically handles pagination.
+func (c *Client) GetConduitShardsByStatus(ctx context.Context, conduitID string, status ShardStatus) (shards []Shard, err error) {
+	return c.fetchConduitShards(ctx, conduitID, status)
+}
+
+func (c *Client) fetchConduitShards(ctx context.Context, conduitID string, status ShardStatus) (shards []Shard, err error) {
+	cursor := ""
+	for {
+		params := url.Values{"conduit_id": {conduitID}}
+		if status != "" {
+			params.Set("status", string(status))
+		}
+		if cursor != "" {
+			params.Set("after", cursor)
+		}
+
+		var responseBody struct {
+			Data       []Shard `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		err := c.retryUnauthorized(ctx, func() error {
+			return c.jsonRequest(ctx, "GET", "/eventsub/conduits/shards?"+params.Encode(), nil, 200, &responseBody)
+		})
+		if err != nil {
+			return nil, conduitNotFound(err)
+		}
+
+		shards = append(shards, responseBody.Data...)
+		if responseBody.Pagination.Cursor == "" {
+			break
+		}
+		cursor = responseBody.Pagination.Cursor
+	}
+	return shards, nil
+}
+
+// UpdateConduitShards assigns transports to shards of a conduit.
+//
+// Twitch may fail to update some of the shards while updating others. In that case the updated shards are
+// returned along with a [ShardUpdateError] containing the shards that failed.
+//
+//	shards, err := client.UpdateConduitShards(ctx, conduit.ID, []twitchwh.ShardUpdate{
+//		client.WebhookShard("0"),
+//		client.WebhookShard("1"),
+//	})
+func (c *Client) UpdateConduitShards(ctx context.Context, conduitID string, shards []ShardUpdate) ([]Shard, error) {
+	reqBody := struct {
+		ConduitID string        `json:"conduit_id"`
+		Shards    []ShardUpdate `json:"shards"`
+	}{conduitID, shards}
+	var responseBody struct {
+		Data   []Shard      `json:"data"`
+		Errors []ShardError `json:"errors"`
+	}
+	err := c.retryUnauthorized(ctx, func() error {
+		return c.jsonRequest(ctx, "PATCH", "/eventsub/conduits/shards", reqBody, 202, &responseBody)
+	})
+	if err != nil {
+		return nil, conduitNotFound(err)
+	}
+	if len(responseBody.Errors) > 0 {
+		return responseBody.Data, &ShardUpdateError{Errors: responseBody.Errors}
+	}
+	return responseBody.Data, nil
+}
+
+// AddConduitSubscription attempts to create a new subscription based on the type, version, and condition,
+// that is delivered to the shards of a conduit instead of the client's webhook URL.
+// You can find all subscription types, versions, and conditions at: [EventSub subscription types].
+//
+// Unlike [Client.AddSubscription], this does not wait for verification since Twitch does not verify conduit subscriptions.
+// Returns the subscription created by Helix.
+//
+// [EventSub subscription types]: https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/
+func (c *Client) AddConduitSubscription(ctx context.Context, conduitID string, Type string, version string, condition Condition) (subscription Subscription, err error) {
+	err = c.retryUnauthorized(ctx, func() error {
+		subscription, err = c.createSubscription(ctx, "", Type, version, condition, transport{
+			Method:    "conduit",
+			ConduitID: conduitID,
+		})
+		return err
+	})
+	if err != nil {
+		return Subscription{}, err
+	}
+	c.logger.Info("Subscription created", subscriptionAttrs(subscription)...)
+	return subscription, nil
+}
+
+// Converts 404 responses into ConduitNotFoundError
+func conduitNotFound(err error) error {
+	var statusErr *UnhandledStatusError
+	if errors.As(err, &statusErr) && statusErr.Status == 404 {
+		return &ConduitNotFoundError{}
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package twitchwh
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Maximum number of message IDs remembered by the in-memory DedupStore used when ClientConfig.DedupStore is nil.
+const defaultDedupMaxEntries = 100_000
+
+// newDefaultDedupStore creates the in-memory DedupStore used when ClientConfig.DedupStore is nil.
+// Handler accepts timestamps up to maxMessageAge in the past or the future, so a captured request can be replayed
+// for up to twice maxMessageAge after it was first received. Its message ID is remembered for that long.
+func newDefaultDedupStore(maxMessageAge time.Duration, now func() time.Time) *MemoryDedupStore {
+	var store *MemoryDedupStore
+	if maxMessageAge < 0 {
+		// Without the timestamp check, requests can be replayed at any time
+		store = NewMemoryDedupStore(0, 0)
+	} else {
+		store = NewMemoryDedupStore(2*maxMessageAge, defaultDedupMaxEntries)
+	}
+	store.now = now
+	return store
+}
+
+// DedupStore keeps track of handled message IDs, so that events retried by Twitch are only dispatched once.
+//
+// The default is an in-memory store created with [NewMemoryDedupStore]. To dedupe across several replicas
+// behind a load balancer, implement DedupStore on top of a shared store (eg. Redis SET NX with an expiry).
+type DedupStore interface {
+	// MarkHandled records the message ID as handled.
+	// Returns false if the message ID has already been marked as handled.
+	//
+	// MarkHandled must be safe for concurrent use. Checking and marking must be atomic,
+	// otherwise two concurrent deliveries of the same message may both be dispatched.
+	MarkHandled(ctx context.Context, messageID string) (bool, error)
+}
+
+// MemoryDedupStore is an in-memory [DedupStore].
+// Message IDs are forgotten after the TTL, and the oldest message IDs are evicted once the store is full.
+type MemoryDedupStore struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu sync.Mutex
+	// Ordered oldest first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type dedupEntry struct {
+	messageID string
+	expires   time.Time
+}
+
+// NewMemoryDedupStore creates a new in-memory [DedupStore] that remembers message IDs for ttl,
+// and at most maxEntries message IDs at once. Message IDs are kept forever if ttl is zero or less,
+// and there is no limit on the number of message IDs if maxEntries is zero or less.
+func NewMemoryDedupStore(ttl time.Duration, maxEntries int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryDedupStore) MarkHandled(ctx context.Context, messageID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.evictExpired(now)
+
+	if _, ok := s.entries[messageID]; ok {
+		return false, nil
+	}
+
+	if s.maxEntries > 0 && s.order.Len() >= s.maxEntries {
+		s.remove(s.order.Front())
+	}
+	s.entries[messageID] = s.order.PushBack(dedupEntry{messageID, now.Add(s.ttl)})
+	return true, nil
+}
+
+// Len returns the number of message IDs currently remembered.
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictExpired(s.now())
+	return s.order.Len()
+}
+
+func (s *MemoryDedupStore) evictExpired(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	// Every entry has the same TTL, so the list is also ordered by expiry
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		if now.Before(e.Value.(dedupEntry).expires) {
+			return
+		}
+		s.remove(e)
+	}
+}
+
+func (s *MemoryDedupStore) remove(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.entries, e.Value.(dedupEntry).messageID)
+}
@@ -0,0 +1,49 @@
+package twitchwh
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryDedupStore(time.Minute, 2)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if first, _ := store.MarkHandled(ctx, "a"); !first {
+		t.Fatal("Expected a to be handled for the first time")
+	}
+	if first, _ := store.MarkHandled(ctx, "a"); first {
+		t.Fatal("Expected a to be a duplicate")
+	}
+
+	// Evicts a since the store is full
+	store.MarkHandled(ctx, "b")
+	store.MarkHandled(ctx, "c")
+	if store.Len() != 2 {
+		t.Fatalf("Expected 2 entries, got %d", store.Len())
+	}
+	if first, _ := store.MarkHandled(ctx, "a"); !first {
+		t.Fatal("Expected a to be evicted")
+	}
+
+	now = now.Add(time.Minute)
+	if store.Len() != 0 {
+		t.Fatalf("Expected all entries to expire, got %d", store.Len())
+	}
+}
+
+func TestDefaultDedupStoreWithoutMaxMessageAge(t *testing.T) {
+	now := time.Now()
+	store := newDefaultDedupStore(-1, func() time.Time { return now })
+	ctx := context.Background()
+
+	store.MarkHandled(ctx, "a")
+	// Without the timestamp check a captured request can be replayed at any time
+	now = now.Add(365 * 24 * time.Hour)
+	if first, _ := store.MarkHandled(ctx, "a"); first {
+		t.Fatal("Expected a to be remembered forever")
+	}
+}
@@ -0,0 +1,351 @@
+package twitchwh
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// QueueFullPolicy decides what happens to a notification when the dispatch queue is full. See [DispatchConfig].
+type QueueFullPolicy int
+
+const (
+	// Wait for a place in the queue. The webhook request is not answered until then, so Twitch may time out and retry it.
+	QueueFullBlock QueueFullPolicy = iota
+	// Drop the oldest queued notification to make room. Dropped notifications are passed to Client.OnError.
+	QueueFullDropOldest
+	// Reject webhook requests with 503 Service Unavailable, so that Twitch retries them later.
+	// Notifications received over the WebSocket transport are dropped instead, since Twitch does not retry them.
+	QueueFullReject
+)
+
+// DispatchConfig is used to configure how notifications are dispatched to handlers. See ClientConfig.Dispatch.
+type DispatchConfig struct {
+	// Number of goroutines that run handlers. If zero, every notification is handled in a new goroutine
+	// once TypeLimits and Ordered allow it, and QueueSize and QueueFullPolicy are ignored.
+	Workers int
+	// Maximum number of notifications waiting for a worker. Defaults to 100 times Workers
+	QueueSize int
+	// Maximum number of notifications of a subscription type handled at once, eg. {"channel.chat.message": 2}.
+	// Notifications of a type at its limit wait in the queue without holding up other types.
+	// Types that are not listed are only limited by Workers.
+	TypeLimits map[string]int
+	// What to do when the queue is full. Defaults to QueueFullBlock
+	QueueFullPolicy QueueFullPolicy
+	// Handle notifications with the same partition key one at a time, oldest Notification.Timestamp first,
+	// eg. so that channel.poll.progress is not handled before channel.poll.begin. Notifications with different
+	// keys still run in parallel. Notifications already being handled can not be reordered, so a notification
+	// that arrives late runs after newer ones that had started.
+	Ordered bool
+	// Returns the partition key of a notification when Ordered is set. Notifications with an empty key are not ordered.
+	// Defaults to the broadcaster user ID of the subscription condition.
+	PartitionKey func(Notification) string
+}
+
+func broadcasterPartitionKey(notification Notification) string {
+	return notification.Subscription.Condition.BroadcasterUserID
+}
+
+const defaultQueueSizePerWorker = 100
+
+var errQueueFull = errors.New("Dispatch queue is full")
+var errDispatcherClosed = errors.New("Client is closed")
+
+type dispatchJob struct {
+	notification Notification
+	// Empty if the notification is not ordered
+	partition string
+	run       func()
+}
+
+// dispatcher runs handlers on a fixed number of workers, or in a new goroutine per notification if there are none.
+// Either way, a queued notification runs once it is below the limit of its type and its partition is not busy.
+//
+// A notification takes a place in the queue with reserve before it is marked as handled, so that a webhook
+// request can still be rejected without the retry being ignored as a duplicate. The place is given back
+// once a worker picks up the notification, or with release if it is not submitted after all.
+type dispatcher struct {
+	workers    int
+	typeLimits map[string]int
+	policy     QueueFullPolicy
+	// Nil if notifications are not ordered
+	partitionKey func(Notification) string
+	// Called with notifications dropped by QueueFullDropOldest
+	dropped func(Notification)
+	// Places in the queue, including reserved ones that are not submitted yet
+	slots chan struct{}
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// Ordered oldest first
+	queue []dispatchJob
+	// Notifications being handled, by subscription type
+	running map[string]int
+	// Partitions with a notification being handled
+	busy map[string]bool
+	// Notifications with a reserved place that are not submitted yet
+	reserved int
+	// Total number of notifications being handled
+	active int
+	closed bool
+	// Closed once the dispatcher is closed and every notification has been handled
+	drained chan struct{}
+}
+
+// newDispatcher creates a dispatcher and starts its workers.
+func newDispatcher(config DispatchConfig, dropped func(Notification)) *dispatcher {
+	d := &dispatcher{
+		workers:    config.Workers,
+		typeLimits: config.TypeLimits,
+		policy:     config.QueueFullPolicy,
+		dropped:    dropped,
+		running:    make(map[string]int),
+		busy:       make(map[string]bool),
+		drained:    make(chan struct{}),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	if config.Ordered {
+		d.partitionKey = config.PartitionKey
+		if d.partitionKey == nil {
+			d.partitionKey = broadcasterPartitionKey
+		}
+	}
+	if d.workers <= 0 {
+		return d
+	}
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSizePerWorker * d.workers
+	}
+	d.slots = make(chan struct{}, queueSize)
+	for range d.workers {
+		go d.work()
+	}
+	return d
+}
+
+// reserve takes a place in the queue, applying the QueueFullPolicy if it is full.
+// Returns errQueueFull or errDispatcherClosed if the notification must be rejected, or the error of ctx if it is done while waiting.
+func (d *dispatcher) reserve(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return errDispatcherClosed
+	}
+	d.reserved++
+	d.mu.Unlock()
+	if d.workers <= 0 {
+		return nil
+	}
+	err := d.reserveSlot(ctx)
+	if err != nil {
+		d.unreserve()
+	}
+	return err
+}
+
+func (d *dispatcher) reserveSlot(ctx context.Context) error {
+	select {
+	case d.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	switch d.policy {
+	case QueueFullReject:
+		return errQueueFull
+	case QueueFullDropOldest:
+		if d.dropOldest() {
+			return nil
+		}
+		// Every place is reserved by a notification that is not submitted yet, so wait for one
+	}
+	select {
+	case d.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dropOldest removes the oldest queued notification, handing its place over to the caller.
+func (d *dispatcher) dropOldest() bool {
+	d.mu.Lock()
+	if len(d.queue) == 0 {
+		d.mu.Unlock()
+		return false
+	}
+	job := d.queue[0]
+	d.queue = d.queue[1:]
+	d.mu.Unlock()
+	if d.dropped != nil {
+		d.dropped(job.notification)
+	}
+	return true
+}
+
+// release gives back a place taken by reserve, for a notification that is not submitted.
+func (d *dispatcher) release() {
+	d.unreserve()
+	if d.workers > 0 {
+		<-d.slots
+	}
+}
+
+func (d *dispatcher) unreserve() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reserved--
+	d.checkDrained()
+}
+
+// submit queues a notification into a place taken by reserve. run is called by a worker,
+// or in a new goroutine if there are no workers.
+func (d *dispatcher) submit(notification Notification, run func()) {
+	job := dispatchJob{notification: notification, run: run}
+	if d.partitionKey != nil {
+		job.partition = d.partitionKey(notification)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reserved--
+	d.queue = append(d.queue, job)
+	if d.workers <= 0 {
+		d.launch()
+		return
+	}
+	d.cond.Signal()
+}
+
+// launch starts a goroutine for every queued notification that can run. Used if there are no workers.
+// Must be called with mu held.
+func (d *dispatcher) launch() {
+	for i := d.next(); i >= 0; i = d.next() {
+		job := d.take(i)
+		go func() {
+			job.run()
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.finish(job)
+			d.launch()
+		}()
+	}
+}
+
+func (d *dispatcher) work() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		i := d.next()
+		if i < 0 {
+			if d.closed && d.reserved == 0 && len(d.queue) == 0 {
+				// Nothing left to handle
+				return
+			}
+			d.cond.Wait()
+			continue
+		}
+		job := d.take(i)
+		d.mu.Unlock()
+
+		<-d.slots
+		job.run()
+
+		d.mu.Lock()
+		d.finish(job)
+	}
+}
+
+// take removes the notification at index i from the queue, and marks it as running. Must be called with mu held.
+func (d *dispatcher) take(i int) dispatchJob {
+	job := d.queue[i]
+	d.queue = append(d.queue[:i], d.queue[i+1:]...)
+	d.running[job.notification.Subscription.Type]++
+	d.active++
+	if job.partition != "" {
+		d.busy[job.partition] = true
+	}
+	return job
+}
+
+// finish marks the notification as no longer running. Must be called with mu held.
+func (d *dispatcher) finish(job dispatchJob) {
+	Type := job.notification.Subscription.Type
+	d.running[Type]--
+	d.active--
+	if job.partition != "" {
+		delete(d.busy, job.partition)
+	}
+	if _, limited := d.typeLimits[Type]; limited || job.partition != "" {
+		// Queued notifications of the type or partition may be able to run now
+		d.cond.Broadcast()
+	}
+	d.checkDrained()
+}
+
+// close stops accepting notifications, and waits until the queued and running ones have been handled, or ctx is done.
+// The workers exit once the queue is empty.
+func (d *dispatcher) close(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.checkDrained()
+	d.mu.Unlock()
+	select {
+	case <-d.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkDrained wakes up idle workers so they exit, and closes drained once nothing is left.
+// Must be called with mu held.
+func (d *dispatcher) checkDrained() {
+	if !d.closed || d.reserved > 0 || len(d.queue) > 0 {
+		return
+	}
+	d.cond.Broadcast()
+	if d.active == 0 {
+		select {
+		case <-d.drained:
+		default:
+			close(d.drained)
+		}
+	}
+}
+
+// next returns the index of the queued notification to run next, or -1 if there is none.
+// That is the oldest queued notification whose type is below its limit, except that notifications
+// of a partition run oldest timestamp first, and only while no other notification of the partition runs.
+// Must be called with mu held.
+func (d *dispatcher) next() int {
+	for i, job := range d.queue {
+		if job.partition != "" {
+			if d.busy[job.partition] {
+				continue
+			}
+			i = d.earliest(job.partition)
+			job = d.queue[i]
+		}
+		Type := job.notification.Subscription.Type
+		limit, ok := d.typeLimits[Type]
+		if !ok || limit <= 0 || d.running[Type] < limit {
+			return i
+		}
+	}
+	return -1
+}
+
+// earliest returns the index of the queued notification of the partition with the oldest timestamp.
+// Must be called with mu held.
+func (d *dispatcher) earliest(partition string) int {
+	earliest := -1
+	for i, job := range d.queue {
+		if job.partition != partition {
+			continue
+		}
+		if earliest < 0 || job.notification.Timestamp.Before(d.queue[earliest].notification.Timestamp) {
+			earliest = i
+		}
+	}
+	return earliest
+}
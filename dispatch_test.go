@@ -0,0 +1,143 @@
+package twitchwh
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newDispatchTestClient returns a test client with the dispatch config, whose handlers block until released.
+func newDispatchTestClient(config DispatchConfig) (c *Client, started chan string, release chan struct{}) {
+	c = newTestClient()
+	c.dispatcher = newDispatcher(config, c.dropped)
+	started = make(chan string, 10)
+	release = make(chan struct{})
+	c.OnUnhandled(func(ctx context.Context, notification Notification) error {
+		started <- notification.MessageID
+		<-release
+		return nil
+	})
+	return c, started, release
+}
+
+func notify(c *Client, messageID string, Type string) int {
+	body := `{"subscription":{"id":"sub","type":"` + Type + `","version":"1"},"event":{}}`
+	w := httptest.NewRecorder()
+	c.Handler(w, newSignedRequest(messageID, messageTypeNotification, time.Now(), body))
+	return w.Code
+}
+
+func expectStarted(t *testing.T, started chan string, messageID string) {
+	t.Helper()
+	select {
+	case got := <-started:
+		if got != messageID {
+			t.Fatalf("Expected %s to start, got %s", messageID, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %s to start", messageID)
+	}
+}
+
+func expectNotStarted(t *testing.T, started chan string) {
+	t.Helper()
+	select {
+	case got := <-started:
+		t.Fatalf("Expected nothing to start, got %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDispatchTypeLimits(t *testing.T) {
+	c, started, release := newDispatchTestClient(DispatchConfig{
+		Workers:    2,
+		TypeLimits: map[string]int{"channel.chat.message": 1},
+	})
+	defer close(release)
+
+	notify(c, "1", "channel.chat.message")
+	expectStarted(t, started, "1")
+	notify(c, "2", "channel.chat.message")
+	expectNotStarted(t, started)
+	// Not held up by the chat message waiting in the queue
+	notify(c, "3", "stream.online")
+	expectStarted(t, started, "3")
+
+	release <- struct{}{}
+	expectStarted(t, started, "2")
+}
+
+func TestDispatchQueueFull(t *testing.T) {
+	t.Run("Reject", func(t *testing.T) {
+		c, started, release := newDispatchTestClient(DispatchConfig{Workers: 1, QueueSize: 1, QueueFullPolicy: QueueFullReject})
+		defer close(release)
+		notify(c, "1", "stream.online")
+		expectStarted(t, started, "1")
+		if code := notify(c, "2", "stream.online"); code != 204 {
+			t.Fatalf("Expected 204, got %d", code)
+		}
+		if code := notify(c, "3", "stream.online"); code != 503 {
+			t.Fatalf("Expected 503, got %d", code)
+		}
+		// The rejected event is not remembered as handled
+		release <- struct{}{}
+		expectStarted(t, started, "2")
+		if code := notify(c, "3", "stream.online"); code != 204 {
+			t.Fatalf("Expected retry to be accepted, got %d", code)
+		}
+		release <- struct{}{}
+		expectStarted(t, started, "3")
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		c, started, release := newDispatchTestClient(DispatchConfig{Workers: 1, QueueSize: 2, QueueFullPolicy: QueueFullDropOldest})
+		defer close(release)
+		errs := make(chan error, 10)
+		c.OnError = func(err error) {
+			errs <- err
+		}
+		notify(c, "1", "stream.online")
+		expectStarted(t, started, "1")
+		for i := 2; i <= 4; i++ {
+			if code := notify(c, strconv.Itoa(i), "stream.online"); code != 204 {
+				t.Fatalf("Expected 204, got %d", code)
+			}
+		}
+		var dropped *EventDroppedError
+		if err := <-errs; !errors.As(err, &dropped) || dropped.MessageID != "2" {
+			t.Fatalf("Expected the oldest event to be dropped, got %v", err)
+		}
+		release <- struct{}{}
+		expectStarted(t, started, "3")
+		release <- struct{}{}
+		expectStarted(t, started, "4")
+	})
+}
+
+func TestDispatchOrdered(t *testing.T) {
+	c, started, release := newDispatchTestClient(DispatchConfig{Ordered: true})
+	defer close(release)
+	now := time.Now()
+	notify := func(messageID string, broadcaster string, timestamp time.Time) {
+		body := `{"subscription":{"id":"sub","type":"channel.poll.progress","version":"1","condition":{"broadcaster_user_id":"` + broadcaster + `"}},"event":{}}`
+		c.Handler(httptest.NewRecorder(), newSignedRequest(messageID, messageTypeNotification, timestamp, body))
+	}
+
+	notify("a3", "a", now.Add(-1*time.Second))
+	expectStarted(t, started, "a3")
+	notify("a2", "a", now.Add(-2*time.Second))
+	notify("a1", "a", now.Add(-3*time.Second))
+	expectNotStarted(t, started)
+	// Other broadcasters are not held up
+	notify("b1", "b", now)
+	expectStarted(t, started, "b1")
+
+	release <- struct{}{}
+	release <- struct{}{}
+	expectStarted(t, started, "a1")
+	release <- struct{}{}
+	expectStarted(t, started, "a2")
+}
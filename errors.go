@@ -1,6 +1,11 @@
 package twitchwh
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // Helix returned an authorization error. This usually means the token, Client-ID, or client secret are invalid.
 type UnauthorizedError struct{}
@@ -38,6 +43,29 @@ func (e *SubscriptionNotFoundError) Error() string {
 	return "Could not find subscription"
 }
 
+// Could not find a conduit with the specified ID.
+type ConduitNotFoundError struct{}
+
+func (e *ConduitNotFoundError) Error() string {
+	return "Could not find conduit"
+}
+
+// ShardError describes why Twitch could not update a single conduit shard.
+type ShardError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// Returned by UpdateConduitShards when Twitch failed to update one or more shards.
+type ShardUpdateError struct {
+	Errors []ShardError
+}
+
+func (e *ShardUpdateError) Error() string {
+	return fmt.Sprintf("Could not update %d shard(s)", len(e.Errors))
+}
+
 // Returned whenever AddSubscription times out waiting for verification confirmation.
 type VerificationTimeoutError struct {
 	Subscription Subscription
@@ -47,6 +75,60 @@ func (e *VerificationTimeoutError) Error() string {
 	return "Subscription was not verified within timeout duration"
 }
 
+// Returned when the max total cost of the client has been reached, and the subscription type costs 1.
+// Types that need user authorization cost nothing, so they are left for Helix to check.
+// The request is not sent to Helix.
+type QuotaExceededError struct {
+	// Type of the subscription that was not created
+	Type string
+	// Quota at the time of the request
+	Quota SubscriptionQuota
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("Max total cost reached (%d/%d)", e.Quota.TotalCost, e.Quota.MaxTotalCost)
+}
+
+// Returned when the user that must authorize a subscription has not granted any of the required scopes to your app.
+type MissingScopeError struct {
+	// Type of the subscription, eg: channel.subscribe
+	Type string
+	// ID of the user that must authorize the subscription. Empty if unknown
+	UserID string
+	// At least one of these scopes is required
+	Scopes []string
+	// The *UnhandledStatusError with the response body if Helix rejected the subscription with 403 Forbidden,
+	// which may also happen for other reasons than missing scopes. Nil if the scopes were checked by the client.
+	OriginalError error
+}
+
+func (e *MissingScopeError) Error() string {
+	message := fmt.Sprintf("User %s has not granted the scopes required for %s (one of %s)", e.UserID, e.Type, strings.Join(e.Scopes, ", "))
+	var statusErr *UnhandledStatusError
+	if errors.As(e.OriginalError, &statusErr) {
+		var response struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(statusErr.Body, &response) == nil && response.Message != "" {
+			message += ": Helix responded with: " + response.Message
+		}
+	}
+	return message
+}
+
+func (e *MissingScopeError) Unwrap() error {
+	return e.OriginalError
+}
+
+// Returned when the client has no user access token for a user.
+type UserTokenNotFoundError struct {
+	UserID string
+}
+
+func (e *UserTokenNotFoundError) Error() string {
+	return fmt.Sprintf("No user access token for user %s", e.UserID)
+}
+
 // Returned for misc errors, like network or serialization errors for example.
 type InternalError struct {
 	message string
@@ -57,3 +139,96 @@ type InternalError struct {
 func (e *InternalError) Error() string {
 	return fmt.Sprintf("%s: %s", e.message, e.OriginalError)
 }
+
+func (e *InternalError) Unwrap() error {
+	return e.OriginalError
+}
+
+// Passed to Client.OnDecodeError whenever an event body could not be decoded into the type given to OnEvent.
+// Also passed to Client.OnError, wrapped in a *HandlerError.
+type EventDecodeError struct {
+	// Subscription type of the event, eg: stream.online
+	Type string
+	// Raw event body that failed to decode
+	Event json.RawMessage
+	// Original error returned by encoding/json
+	OriginalError error
+}
+
+func (e *EventDecodeError) Error() string {
+	return fmt.Sprintf("Could not decode %s event: %s", e.Type, e.OriginalError)
+}
+
+func (e *EventDecodeError) Unwrap() error {
+	return e.OriginalError
+}
+
+// Passed to Client.OnError whenever a handler returned an error or panicked.
+type HandlerError struct {
+	// Subscription type of the event, eg: stream.online
+	Type      string
+	MessageID string
+	// Error returned by the handler, or a *PanicError if it panicked
+	OriginalError error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("Handler for %s event failed: %s", e.Type, e.OriginalError)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.OriginalError
+}
+
+// A handler or middleware panicked. The panic is recovered and passed to Client.OnError, wrapped in a *HandlerError.
+type PanicError struct {
+	// Value passed to panic
+	Value any
+	// Stack trace of the goroutine that panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Handler panicked: %v", e.Value)
+}
+
+// Passed to Client.OnError whenever a notification is dropped because the dispatch queue is full. See [DispatchConfig].
+type EventDroppedError struct {
+	// Subscription type of the event, eg: stream.online
+	Type      string
+	MessageID string
+}
+
+func (e *EventDroppedError) Error() string {
+	return fmt.Sprintf("Dispatch queue is full, dropped %s event", e.Type)
+}
+
+// Passed to Client.OnError whenever a webhook request is rejected because of an invalid signature.
+type InvalidSignatureError struct {
+	MessageID  string
+	RemoteAddr string
+}
+
+func (e *InvalidSignatureError) Error() string {
+	return "Invalid signature"
+}
+
+// Returned when the WebSocket did not receive any message within the keepalive timeout.
+// The session is gone along with all of its subscriptions.
+type KeepaliveTimeoutError struct {
+	SessionID string
+}
+
+func (e *KeepaliveTimeoutError) Error() string {
+	return "No message received within keepalive timeout"
+}
+
+// Returned when Twitch sent a WebSocket message of another type than expected, eg. when the first message is not session_welcome.
+type UnexpectedMessageError struct {
+	Expected string
+	Got      string
+}
+
+func (e *UnexpectedMessageError) Error() string {
+	return fmt.Sprintf("Expected %s message, got %s", e.Expected, e.Got)
+}
@@ -0,0 +1,440 @@
+package twitchwh
+
+import "time"
+
+// Typed event payloads for the EventSub subscription types.
+// Use these with [OnEvent] to have the event body decoded before your handler is called.
+//
+// Field names follow the Twitch documentation, see: https://dev.twitch.tv/docs/eventsub/eventsub-reference/#events
+
+// StreamOnlineEvent is the event body for stream.online
+type StreamOnlineEvent struct {
+	ID                   string `json:"id"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	// live, playlist, watch_party, premiere, or rerun
+	Type      string    `json:"type"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// StreamOfflineEvent is the event body for stream.offline
+type StreamOfflineEvent struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+}
+
+// ChannelUpdateEvent is the event body for channel.update
+type ChannelUpdateEvent struct {
+	BroadcasterUserID           string   `json:"broadcaster_user_id"`
+	BroadcasterUserLogin        string   `json:"broadcaster_user_login"`
+	BroadcasterUserName         string   `json:"broadcaster_user_name"`
+	Title                       string   `json:"title"`
+	Language                    string   `json:"language"`
+	CategoryID                  string   `json:"category_id"`
+	CategoryName                string   `json:"category_name"`
+	ContentClassificationLabels []string `json:"content_classification_labels"`
+}
+
+// ChannelFollowEvent is the event body for channel.follow
+type ChannelFollowEvent struct {
+	UserID               string    `json:"user_id"`
+	UserLogin            string    `json:"user_login"`
+	UserName             string    `json:"user_name"`
+	BroadcasterUserID    string    `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string    `json:"broadcaster_user_login"`
+	BroadcasterUserName  string    `json:"broadcaster_user_name"`
+	FollowedAt           time.Time `json:"followed_at"`
+}
+
+// ChannelSubscribeEvent is the event body for channel.subscribe
+type ChannelSubscribeEvent struct {
+	UserID               string `json:"user_id"`
+	UserLogin            string `json:"user_login"`
+	UserName             string `json:"user_name"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	// 1000, 2000, or 3000
+	Tier   string `json:"tier"`
+	IsGift bool   `json:"is_gift"`
+}
+
+// ChannelSubscriptionEndEvent is the event body for channel.subscription.end
+type ChannelSubscriptionEndEvent ChannelSubscribeEvent
+
+// ChannelSubscriptionGiftEvent is the event body for channel.subscription.gift
+type ChannelSubscriptionGiftEvent struct {
+	// Empty if IsAnonymous is true
+	UserID string `json:"user_id"`
+	// Empty if IsAnonymous is true
+	UserLogin string `json:"user_login"`
+	// Empty if IsAnonymous is true
+	UserName             string `json:"user_name"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	Total                int    `json:"total"`
+	Tier                 string `json:"tier"`
+	// Nil if IsAnonymous is true or the user has opted out of sharing this
+	CumulativeTotal *int `json:"cumulative_total"`
+	IsAnonymous     bool `json:"is_anonymous"`
+}
+
+// ChannelSubscriptionMessageEvent is the event body for channel.subscription.message
+type ChannelSubscriptionMessageEvent struct {
+	UserID               string              `json:"user_id"`
+	UserLogin            string              `json:"user_login"`
+	UserName             string              `json:"user_name"`
+	BroadcasterUserID    string              `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string              `json:"broadcaster_user_login"`
+	BroadcasterUserName  string              `json:"broadcaster_user_name"`
+	Tier                 string              `json:"tier"`
+	Message              SubscriptionMessage `json:"message"`
+	CumulativeMonths     int                 `json:"cumulative_months"`
+	// Nil if the user has opted out of sharing this
+	StreakMonths   *int `json:"streak_months"`
+	DurationMonths int  `json:"duration_months"`
+}
+
+// ChannelCheerEvent is the event body for channel.cheer
+type ChannelCheerEvent struct {
+	IsAnonymous bool `json:"is_anonymous"`
+	// Empty if IsAnonymous is true
+	UserID string `json:"user_id"`
+	// Empty if IsAnonymous is true
+	UserLogin string `json:"user_login"`
+	// Empty if IsAnonymous is true
+	UserName             string `json:"user_name"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	Message              string `json:"message"`
+	Bits                 int    `json:"bits"`
+}
+
+// ChannelRaidEvent is the event body for channel.raid
+type ChannelRaidEvent struct {
+	FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+	FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+	ToBroadcasterUserID      string `json:"to_broadcaster_user_id"`
+	ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+	ToBroadcasterUserName    string `json:"to_broadcaster_user_name"`
+	Viewers                  int    `json:"viewers"`
+}
+
+// ChannelBanEvent is the event body for channel.ban
+type ChannelBanEvent struct {
+	UserID               string    `json:"user_id"`
+	UserLogin            string    `json:"user_login"`
+	UserName             string    `json:"user_name"`
+	BroadcasterUserID    string    `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string    `json:"broadcaster_user_login"`
+	BroadcasterUserName  string    `json:"broadcaster_user_name"`
+	ModeratorUserID      string    `json:"moderator_user_id"`
+	ModeratorUserLogin   string    `json:"moderator_user_login"`
+	ModeratorUserName    string    `json:"moderator_user_name"`
+	Reason               string    `json:"reason"`
+	BannedAt             time.Time `json:"banned_at"`
+	// Nil if IsPermanent is true
+	EndsAt      *time.Time `json:"ends_at"`
+	IsPermanent bool       `json:"is_permanent"`
+}
+
+// ChannelUnbanEvent is the event body for channel.unban
+type ChannelUnbanEvent struct {
+	UserID               string `json:"user_id"`
+	UserLogin            string `json:"user_login"`
+	UserName             string `json:"user_name"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	ModeratorUserID      string `json:"moderator_user_id"`
+	ModeratorUserLogin   string `json:"moderator_user_login"`
+	ModeratorUserName    string `json:"moderator_user_name"`
+}
+
+// ChannelModeratorEvent is the event body for channel.moderator.add and channel.moderator.remove
+type ChannelModeratorEvent struct {
+	UserID               string `json:"user_id"`
+	UserLogin            string `json:"user_login"`
+	UserName             string `json:"user_name"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+}
+
+// ChannelPointsRedemptionEvent is the event body for channel.channel_points_custom_reward_redemption.add and .update
+type ChannelPointsRedemptionEvent struct {
+	ID                   string `json:"id"`
+	UserID               string `json:"user_id"`
+	UserLogin            string `json:"user_login"`
+	UserName             string `json:"user_name"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	UserInput            string `json:"user_input"`
+	// unknown, unfulfilled, fulfilled, or canceled
+	Status string `json:"status"`
+	Reward struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Cost   int    `json:"cost"`
+		Prompt string `json:"prompt"`
+	} `json:"reward"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+// PollChoice is a single choice in a poll event
+type PollChoice struct {
+	ID                 string `json:"id"`
+	Title              string `json:"title"`
+	ChannelPointsVotes int    `json:"channel_points_votes"`
+	Votes              int    `json:"votes"`
+}
+
+// ChannelPollEvent is the event body for channel.poll.begin, channel.poll.progress, and channel.poll.end
+type ChannelPollEvent struct {
+	ID                   string       `json:"id"`
+	BroadcasterUserID    string       `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string       `json:"broadcaster_user_login"`
+	BroadcasterUserName  string       `json:"broadcaster_user_name"`
+	Title                string       `json:"title"`
+	Choices              []PollChoice `json:"choices"`
+	ChannelPointsVoting  struct {
+		IsEnabled     bool `json:"is_enabled"`
+		AmountPerVote int  `json:"amount_per_vote"`
+	} `json:"channel_points_voting"`
+	StartedAt time.Time `json:"started_at"`
+	// Only set for channel.poll.begin and channel.poll.progress
+	EndsAt *time.Time `json:"ends_at"`
+	// Only set for channel.poll.end. completed, archived, or terminated
+	Status string `json:"status"`
+	// Only set for channel.poll.end
+	EndedAt *time.Time `json:"ended_at"`
+}
+
+// Predictor is a user that participated in a prediction
+type Predictor struct {
+	UserID            string `json:"user_id"`
+	UserLogin         string `json:"user_login"`
+	UserName          string `json:"user_name"`
+	ChannelPointsWon  *int   `json:"channel_points_won"`
+	ChannelPointsUsed int    `json:"channel_points_used"`
+}
+
+// PredictionOutcome is a single outcome in a prediction event
+type PredictionOutcome struct {
+	ID            string      `json:"id"`
+	Title         string      `json:"title"`
+	Color         string      `json:"color"`
+	Users         int         `json:"users"`
+	ChannelPoints int         `json:"channel_points"`
+	TopPredictors []Predictor `json:"top_predictors"`
+}
+
+// ChannelPredictionEvent is the event body for channel.prediction.begin, .progress, .lock, and .end
+type ChannelPredictionEvent struct {
+	ID                   string              `json:"id"`
+	BroadcasterUserID    string              `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string              `json:"broadcaster_user_login"`
+	BroadcasterUserName  string              `json:"broadcaster_user_name"`
+	Title                string              `json:"title"`
+	Outcomes             []PredictionOutcome `json:"outcomes"`
+	StartedAt            time.Time           `json:"started_at"`
+	// Only set for channel.prediction.begin and channel.prediction.progress
+	LocksAt *time.Time `json:"locks_at"`
+	// Only set for channel.prediction.lock
+	LockedAt *time.Time `json:"locked_at"`
+	// Only set for channel.prediction.end
+	WinningOutcomeID string `json:"winning_outcome_id"`
+	// Only set for channel.prediction.end. resolved or canceled
+	Status string `json:"status"`
+	// Only set for channel.prediction.end
+	EndedAt *time.Time `json:"ended_at"`
+}
+
+// HypeTrainContribution is a single contribution to a hype train
+type HypeTrainContribution struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+	// bits, subscription, or other
+	Type  string `json:"type"`
+	Total int    `json:"total"`
+}
+
+// ChannelHypeTrainEvent is the event body for channel.hype_train.begin, .progress, and .end
+type ChannelHypeTrainEvent struct {
+	ID                   string                  `json:"id"`
+	BroadcasterUserID    string                  `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string                  `json:"broadcaster_user_login"`
+	BroadcasterUserName  string                  `json:"broadcaster_user_name"`
+	Level                int                     `json:"level"`
+	Total                int                     `json:"total"`
+	Progress             int                     `json:"progress"`
+	Goal                 int                     `json:"goal"`
+	TopContributions     []HypeTrainContribution `json:"top_contributions"`
+	LastContribution     *HypeTrainContribution  `json:"last_contribution"`
+	StartedAt            time.Time               `json:"started_at"`
+	// Only set for channel.hype_train.begin and channel.hype_train.progress
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Only set for channel.hype_train.end
+	EndedAt *time.Time `json:"ended_at"`
+	// Only set for channel.hype_train.end
+	CooldownEndsAt *time.Time `json:"cooldown_ends_at"`
+}
+
+// ChannelShoutoutCreateEvent is the event body for channel.shoutout.create
+type ChannelShoutoutCreateEvent struct {
+	BroadcasterUserID      string    `json:"broadcaster_user_id"`
+	BroadcasterUserLogin   string    `json:"broadcaster_user_login"`
+	BroadcasterUserName    string    `json:"broadcaster_user_name"`
+	ToBroadcasterUserID    string    `json:"to_broadcaster_user_id"`
+	ToBroadcasterUserLogin string    `json:"to_broadcaster_user_login"`
+	ToBroadcasterUserName  string    `json:"to_broadcaster_user_name"`
+	ModeratorUserID        string    `json:"moderator_user_id"`
+	ModeratorUserLogin     string    `json:"moderator_user_login"`
+	ModeratorUserName      string    `json:"moderator_user_name"`
+	ViewerCount            int       `json:"viewer_count"`
+	StartedAt              time.Time `json:"started_at"`
+	CooldownEndsAt         time.Time `json:"cooldown_ends_at"`
+	TargetCooldownEndsAt   time.Time `json:"target_cooldown_ends_at"`
+}
+
+// ChannelShoutoutReceiveEvent is the event body for channel.shoutout.receive
+type ChannelShoutoutReceiveEvent struct {
+	BroadcasterUserID        string    `json:"broadcaster_user_id"`
+	BroadcasterUserLogin     string    `json:"broadcaster_user_login"`
+	BroadcasterUserName      string    `json:"broadcaster_user_name"`
+	FromBroadcasterUserID    string    `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string    `json:"from_broadcaster_user_login"`
+	FromBroadcasterUserName  string    `json:"from_broadcaster_user_name"`
+	ViewerCount              int       `json:"viewer_count"`
+	StartedAt                time.Time `json:"started_at"`
+}
+
+// SubscriptionMessage is the message a user shared with their resubscription, used by channel.subscription.message
+type SubscriptionMessage struct {
+	Text string `json:"text"`
+	// Emotes used in Text. Nil if the message has no emotes
+	Emotes []SubscriptionMessageEmote `json:"emotes"`
+}
+
+// SubscriptionMessageEmote is the position of an emote in a SubscriptionMessage
+type SubscriptionMessageEmote struct {
+	// Index of the first character of the emote in the message text
+	Begin int `json:"begin"`
+	// Index of the last character of the emote in the message text
+	End int    `json:"end"`
+	ID  string `json:"id"`
+}
+
+// Message is a chat message with emote and mention fragments. Used by channel.chat.message.
+type Message struct {
+	Text      string            `json:"text"`
+	Fragments []MessageFragment `json:"fragments"`
+}
+
+// MessageFragment is a single part of a chat message
+type MessageFragment struct {
+	// text, cheermote, emote, or mention
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+	Cheermote *struct {
+		Prefix string `json:"prefix"`
+		Bits   int    `json:"bits"`
+		Tier   int    `json:"tier"`
+	} `json:"cheermote"`
+	Emote *struct {
+		ID         string   `json:"id"`
+		EmoteSetID string   `json:"emote_set_id"`
+		OwnerID    string   `json:"owner_id"`
+		Format     []string `json:"format"`
+	} `json:"emote"`
+	Mention *struct {
+		UserID    string `json:"user_id"`
+		UserLogin string `json:"user_login"`
+		UserName  string `json:"user_name"`
+	} `json:"mention"`
+}
+
+// ChatBadge is a badge shown next to a chatters name
+type ChatBadge struct {
+	SetID string `json:"set_id"`
+	ID    string `json:"id"`
+	Info  string `json:"info"`
+}
+
+// ChannelChatMessageEvent is the event body for channel.chat.message
+type ChannelChatMessageEvent struct {
+	BroadcasterUserID    string  `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string  `json:"broadcaster_user_login"`
+	BroadcasterUserName  string  `json:"broadcaster_user_name"`
+	ChatterUserID        string  `json:"chatter_user_id"`
+	ChatterUserLogin     string  `json:"chatter_user_login"`
+	ChatterUserName      string  `json:"chatter_user_name"`
+	MessageID            string  `json:"message_id"`
+	Message              Message `json:"message"`
+	// text, channel_points_highlighted, channel_points_sub_only, user_intro, power_ups_message_effect, or power_ups_gigantified_emote
+	MessageType string      `json:"message_type"`
+	Badges      []ChatBadge `json:"badges"`
+	Color       string      `json:"color"`
+	Cheer       *struct {
+		Bits int `json:"bits"`
+	} `json:"cheer"`
+	Reply *struct {
+		ParentMessageID   string `json:"parent_message_id"`
+		ParentMessageBody string `json:"parent_message_body"`
+		ParentUserID      string `json:"parent_user_id"`
+		ParentUserLogin   string `json:"parent_user_login"`
+		ParentUserName    string `json:"parent_user_name"`
+		ThreadMessageID   string `json:"thread_message_id"`
+		ThreadUserID      string `json:"thread_user_id"`
+		ThreadUserLogin   string `json:"thread_user_login"`
+		ThreadUserName    string `json:"thread_user_name"`
+	} `json:"reply"`
+	ChannelPointsCustomRewardID string `json:"channel_points_custom_reward_id"`
+}
+
+// ChannelChatMessageDeleteEvent is the event body for channel.chat.message_delete
+type ChannelChatMessageDeleteEvent struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	TargetUserID         string `json:"target_user_id"`
+	TargetUserLogin      string `json:"target_user_login"`
+	TargetUserName       string `json:"target_user_name"`
+	MessageID            string `json:"message_id"`
+}
+
+// UserUpdateEvent is the event body for user.update
+type UserUpdateEvent struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+	// Only set if the app has the user:read:email scope
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Description   string `json:"description"`
+}
+
+// UserAuthorizationGrantEvent is the event body for user.authorization.grant
+type UserAuthorizationGrantEvent struct {
+	ClientID  string `json:"client_id"`
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+}
+
+// UserAuthorizationRevokeEvent is the event body for user.authorization.revoke
+type UserAuthorizationRevokeEvent struct {
+	ClientID string `json:"client_id"`
+	UserID   string `json:"user_id"`
+	// Empty if the user no longer exists
+	UserLogin string `json:"user_login"`
+	// Empty if the user no longer exists
+	UserName string `json:"user_name"`
+}
@@ -0,0 +1,75 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestOnEvent(t *testing.T) {
+	c := newTestClient()
+
+	var received StreamOnlineEvent
+	OnEvent(c, "stream.online", func(event StreamOnlineEvent) {
+		received = event
+	})
+	var decodeErr *EventDecodeError
+	c.OnDecodeError = func(err *EventDecodeError) {
+		decodeErr = err
+	}
+
+	handle := c.handlers["stream.online"][0].handle
+	handle(context.Background(), Notification{Event: json.RawMessage(`{"broadcaster_user_login":"linneb","started_at":"2024-06-07T12:00:00Z"}`)})
+	if received.BroadcasterUserLogin != "linneb" {
+		t.Fatalf("Expected broadcaster_user_login to be decoded, got %q", received.BroadcasterUserLogin)
+	}
+
+	received = StreamOnlineEvent{}
+	handle(context.Background(), Notification{Event: json.RawMessage(`{"started_at":"not a timestamp"}`)})
+	if received != (StreamOnlineEvent{}) {
+		t.Fatal("Handler was called with an invalid event body")
+	}
+	if decodeErr == nil || decodeErr.Type != "stream.online" {
+		t.Fatal("OnDecodeError was not called")
+	}
+}
+
+func TestChannelSubscriptionMessageEvent(t *testing.T) {
+	// Example payload from https://dev.twitch.tv/docs/eventsub/eventsub-reference/#channel-subscription-message-event
+	body := `{
+		"user_id": "1234",
+		"user_login": "cool_user",
+		"user_name": "Cool_User",
+		"broadcaster_user_id": "1337",
+		"broadcaster_user_login": "cooler_user",
+		"broadcaster_user_name": "Cooler_User",
+		"tier": "1000",
+		"message": {
+			"text": "Love the stream! FevziGG",
+			"emotes": [
+				{
+					"begin": 23,
+					"end": 30,
+					"id": "302976485"
+				}
+			]
+		},
+		"cumulative_months": 15,
+		"streak_months": 1,
+		"duration_months": 6
+	}`
+	var event ChannelSubscriptionMessageEvent
+	err := json.Unmarshal([]byte(body), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Message.Text != "Love the stream! FevziGG" {
+		t.Fatalf("Unexpected message text %q", event.Message.Text)
+	}
+	if len(event.Message.Emotes) != 1 || event.Message.Emotes[0] != (SubscriptionMessageEmote{Begin: 23, End: 30, ID: "302976485"}) {
+		t.Fatalf("Unexpected emotes %+v", event.Message.Emotes)
+	}
+	if event.CumulativeMonths != 15 || event.StreakMonths == nil || *event.StreakMonths != 1 || event.DurationMonths != 6 {
+		t.Fatalf("Unexpected months %+v", event)
+	}
+}
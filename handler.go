@@ -1,10 +1,16 @@
 package twitchwh
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
-	"slices"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // List of request headers sent from Twitch
@@ -13,12 +19,25 @@ const twitchMessageID = "Twitch-Eventsub-Message-Id"
 const twitchMessageTimestamp = "Twitch-Eventsub-Message-Timestamp"
 const twitchMessageSignature = "Twitch-Eventsub-Message-Signature"
 const messageType = "Twitch-Eventsub-Message-Type"
+const twitchMessageRetry = "Twitch-Eventsub-Message-Retry"
+const twitchSubscriptionType = "Twitch-Eventsub-Subscription-Type"
+const twitchSubscriptionVersion = "Twitch-Eventsub-Subscription-Version"
 
 // Message types
 const messageTypeNotification = "notification"
 const messageTypeVerification = "webhook_callback_verification"
 const messageTypeRevocation = "revocation"
 
+// StaleMessage describes a request that was rejected because its timestamp is outside of ClientConfig.MaxMessageAge.
+type StaleMessage struct {
+	MessageID   string
+	MessageType string
+	// Zero if the timestamp header is missing or invalid
+	Timestamp time.Time
+	// How old the message was when it was received. Negative for messages dated in the future.
+	Age time.Duration
+}
+
 type webhookPayload struct {
 	Challenge    string          `json:"challenge"`
 	Subscription Subscription    `json:"subscription"`
@@ -36,66 +55,297 @@ type webhookPayload struct {
 //	http.ListenAndServe(":443", nil)
 //
 // This example assumes https://mydomain.com is pointing to the Go app.
+//
+// Requests with an invalid signature are rejected with 403 Forbidden. Signatures made with the previous
+// webhook secrets are accepted while they are being rotated out, see [Client.RotateWebhookSecret].
+// Requests with a timestamp outside of ClientConfig.MaxMessageAge are rejected with 400 Bad Request.
+// Notifications are rejected with 503 Service Unavailable when the dispatch queue is full (see [DispatchConfig])
+// or the client is closed, so that Twitch retries them.
+//
+// Every request is traced in a span, which is the parent of the spans of the handlers it dispatches to.
 func (c *Client) Handler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := c.tracer.Start(r.Context(), "eventsub webhook",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(webhookAttrs(r)...),
+	)
+	defer span.End()
+
+	logger := c.logger.With(
+		"message_id", r.Header.Get(twitchMessageID),
+		"message_type", r.Header.Get(messageType),
+	)
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		c.logger.Printf("Could not read request body: %s", err)
+		logger.Error("Could not read request body", "error", err)
+		c.reportError(&InternalError{"Could not read request body", err})
+		spanError(span, "Could not read request body")
 		w.WriteHeader(500)
 		return
 	}
 
 	hmacMessage := r.Header.Get(twitchMessageID) + r.Header.Get(twitchMessageTimestamp) + string(body)
-	expectedSignature := "sha256=" + generateHmac(c.webhookSecret, hmacMessage)
-	if verifyHmac(expectedSignature, r.Header.Get(twitchMessageSignature)) {
-		c.logger.Println("Received valid signature")
+	if c.webhookSecrets.verify(hmacMessage, r.Header.Get(twitchMessageSignature)) {
+		if !c.checkTimestamp(logger, r) {
+			spanError(span, "Stale message")
+			w.WriteHeader(400)
+			return
+		}
 
 		var payload webhookPayload
 		err := json.Unmarshal(body, &payload)
 		if err != nil {
-			c.logger.Printf("Could not serialize webhook payload: %s", err)
+			logger.Error("Could not parse webhook payload", "error", err)
+			c.reportError(&InternalError{"Could not parse webhook payload", err})
+			spanError(span, "Could not parse webhook payload")
 			w.WriteHeader(500)
 			return
 		}
+		logger = logger.With(subscriptionAttrs(payload.Subscription)...)
+		span.SetAttributes(subscriptionSpanAttrs(payload.Subscription)...)
 
 		message_type := r.Header.Get(messageType)
 		if message_type == messageTypeNotification {
-			c.logger.Printf("Received event for %s ", payload.Subscription.Type)
-			if slices.Contains(c.handledEvents, r.Header.Get(twitchMessageID)) {
-				c.logger.Println("Got request for handled event, ignoring...")
+			logger.Debug("Received event")
+			err := c.dispatcher.reserve(ctx)
+			if err != nil {
+				// Twitch retries the event later
+				if errors.Is(err, errDispatcherClosed) {
+					logger.Info("Rejected event, client is closed")
+				} else {
+					logger.Warn("Rejected event, dispatch queue is full", "error", err)
+				}
+				if errors.Is(err, errQueueFull) {
+					c.metrics.EventDropped(payload.Subscription.Type)
+				}
+				spanError(span, err.Error())
+				w.WriteHeader(503)
+				return
+			}
+			first, err := c.dedupStore.MarkHandled(ctx, r.Header.Get(twitchMessageID))
+			if err != nil {
+				// Let Twitch retry rather than risk dispatching the event twice
+				c.dispatcher.release()
+				logger.Error("Could not mark event as handled", "error", err)
+				c.reportError(&InternalError{"Could not mark event as handled", err})
+				spanError(span, "Could not mark event as handled")
+				w.WriteHeader(500)
+				return
+			}
+			if !first {
+				c.dispatcher.release()
+				logger.Debug("Ignoring duplicate event")
+				c.metrics.DuplicateDelivery(payload.Subscription.Type)
 				w.WriteHeader(204)
 				return
-			} else {
-				c.handledEvents = append(c.handledEvents, r.Header.Get(twitchMessageID))
 			}
 
-			if handler, ok := c.handlers[payload.Subscription.Type]; ok {
-				go handler(payload.Event)
-			} else {
-				c.logger.Printf("No handler for event %s", payload.Subscription.Type)
-			}
+			timestamp, _ := time.Parse(time.RFC3339Nano, r.Header.Get(twitchMessageTimestamp))
+			c.dispatch(ctx, logger, Notification{
+				MessageID:    r.Header.Get(twitchMessageID),
+				Timestamp:    timestamp,
+				Subscription: payload.Subscription,
+				Event:        payload.Event,
+			})
 
 			w.WriteHeader(204)
 			return
 		}
 		if message_type == messageTypeVerification {
-			c.logger.Printf("Got challenge request for %s", payload.Subscription.ID)
-			go func() {
-				c.verifiedSubscriptions <- payload.Subscription.ID
-			}()
+			logger.Info("Received verification challenge")
+			c.metrics.VerificationChallenge()
+			c.markVerified(payload.Subscription.ID)
 			w.WriteHeader(200)
 			w.Write([]byte(payload.Challenge))
 			return
 		}
 		if message_type == messageTypeRevocation {
-			// Subscription was revoked. This could be as simple as a user deactivating or Twitch not reaching the endpoint.
-			c.logger.Printf("Twitch revoked subscription %s", payload.Subscription.ID)
-			if c.OnRevocation != nil {
-				c.OnRevocation(payload.Subscription)
-			}
+			c.revoke(logger, payload.Subscription)
 			w.WriteHeader(204)
 			return
 		}
 	} else {
+		logger.Warn("Rejected request with invalid signature", "remote_addr", r.RemoteAddr)
+		c.metrics.SignatureFailure()
+		c.reportError(&InvalidSignatureError{MessageID: r.Header.Get(twitchMessageID), RemoteAddr: r.RemoteAddr})
+		spanError(span, "Invalid signature")
 		w.WriteHeader(403)
 	}
 }
+
+// dispatch runs the middleware, and the handlers assigned to the event type, if any.
+// They run on the dispatcher, with a context that carries the span of ctx but is never cancelled.
+// A place in the dispatch queue must have been reserved. Errors and panics are logged and passed to Client.OnError.
+func (c *Client) dispatch(ctx context.Context, logger *slog.Logger, notification Notification) {
+	c.metrics.Notification(notification.Subscription.Type)
+	handle := c.chain(func(ctx context.Context, notification Notification) error {
+		handlers := c.handlersFor(notification.Subscription.Type)
+		if len(handlers) == 0 {
+			logger.Debug("No handler for event")
+			return nil
+		}
+		var errs []error
+		for _, handler := range handlers {
+			err := c.runHandler(ctx, handler.handle, notification)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+	c.dispatcher.submit(notification, func() {
+		err := c.recoverMiddleware(context.WithoutCancel(ctx), handle, notification)
+		if err != nil {
+			logger.Error("Could not handle event", "error", err)
+			c.reportError(err)
+		}
+	})
+}
+
+// dropped is called by the dispatcher with notifications dropped because the queue is full.
+func (c *Client) dropped(notification Notification) {
+	c.logger.Warn("Dropped event, dispatch queue is full", append(subscriptionAttrs(notification.Subscription), "message_id", notification.MessageID)...)
+	c.metrics.EventDropped(notification.Subscription.Type)
+	c.reportError(&EventDroppedError{Type: notification.Subscription.Type, MessageID: notification.MessageID})
+}
+
+// recoverMiddleware runs the middleware chain, and returns a *HandlerError if it panicked.
+// Panics of the handlers themselves are recovered by runHandler.
+func (c *Client) recoverMiddleware(ctx context.Context, handle HandlerFunc, notification Notification) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.metrics.HandlerPanic(notification.Subscription.Type)
+			err = &HandlerError{
+				Type:          notification.Subscription.Type,
+				MessageID:     notification.MessageID,
+				OriginalError: &PanicError{Value: r, Stack: debug.Stack()},
+			}
+		}
+	}()
+	return handle(ctx, notification)
+}
+
+// runHandler runs the handler in a span, and records its duration.
+// Returns a *HandlerError if the handler returned an error or panicked.
+func (c *Client) runHandler(ctx context.Context, handler HandlerFunc, notification Notification) (err error) {
+	Type := notification.Subscription.Type
+	ctx, span := c.tracer.Start(ctx, "eventsub handle "+Type, trace.WithAttributes(attrSubscriptionType.String(Type)))
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			c.metrics.HandlerPanic(Type)
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		} else {
+			c.metrics.HandlerDuration(Type, time.Since(start))
+		}
+		if err != nil {
+			spanError(span, err.Error())
+			err = &HandlerError{Type: Type, MessageID: notification.MessageID, OriginalError: err}
+		}
+		span.End()
+	}()
+	return handler(ctx, notification)
+}
+
+// reportError passes the error to Client.OnError, if set.
+func (c *Client) reportError(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// revoke fires Client.OnRevocation for a revoked subscription.
+func (c *Client) revoke(logger *slog.Logger, subscription Subscription) {
+	// Subscription was revoked. This could be as simple as a user deactivating or Twitch not reaching the endpoint.
+	logger.Warn("Twitch revoked subscription", "status", subscription.Status)
+	c.metrics.Revocation(subscription.Status)
+	if c.OnRevocation != nil {
+		c.OnRevocation(subscription)
+	}
+}
+
+// checkTimestamp verifies that the message timestamp is within ClientConfig.MaxMessageAge of the current time.
+// This prevents captured requests from being replayed once their message ID has been forgotten by the DedupStore.
+func (c *Client) checkTimestamp(logger *slog.Logger, r *http.Request) bool {
+	if c.maxMessageAge < 0 {
+		return true
+	}
+
+	stale := StaleMessage{
+		MessageID:   r.Header.Get(twitchMessageID),
+		MessageType: r.Header.Get(messageType),
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, r.Header.Get(twitchMessageTimestamp))
+	if err == nil {
+		stale.Timestamp = timestamp
+		stale.Age = c.clock().Sub(timestamp)
+		if stale.Age <= c.maxMessageAge && stale.Age >= -c.maxMessageAge {
+			return true
+		}
+		logger.Warn("Rejected stale message", "timestamp", timestamp, "age", stale.Age)
+	} else {
+		logger.Warn("Rejected message with invalid timestamp", "error", err)
+	}
+
+	if c.OnStaleMessage != nil {
+		c.OnStaleMessage(stale)
+	}
+	return false
+}
+
+// Verified subscriptions nobody waited for (eg. created by another process) are forgotten after this long.
+const verificationRetention = time.Hour
+
+type verification struct {
+	verified chan struct{}
+	created  time.Time
+}
+
+// awaitVerification returns a channel that is closed once the subscription has been verified.
+// The challenge request may arrive before Helix has responded with the subscription ID, so the
+// entry is created by whichever of awaitVerification and markVerified is called first.
+func (c *Client) awaitVerification(id string) <-chan struct{} {
+	c.verificationsMu.Lock()
+	defer c.verificationsMu.Unlock()
+	return c.verification(id).verified
+}
+
+// forgetVerification removes the entry created by awaitVerification.
+func (c *Client) forgetVerification(id string) {
+	c.verificationsMu.Lock()
+	defer c.verificationsMu.Unlock()
+	delete(c.verifications, id)
+}
+
+// markVerified is called by Client.Handler when Twitch sends the challenge request for a subscription.
+func (c *Client) markVerified(id string) {
+	c.verificationsMu.Lock()
+	defer c.verificationsMu.Unlock()
+
+	now := time.Now()
+	for other, v := range c.verifications {
+		if now.Sub(v.created) > verificationRetention {
+			delete(c.verifications, other)
+		}
+	}
+
+	v := c.verification(id)
+	select {
+	case <-v.verified:
+		// Twitch sent the challenge more than once
+	default:
+		close(v.verified)
+	}
+}
+
+// verification returns the entry for the ID, creating it if necessary. Must be called with verificationsMu held.
+func (c *Client) verification(id string) *verification {
+	if c.verifications == nil {
+		c.verifications = make(map[string]*verification)
+	}
+	v, ok := c.verifications[id]
+	if !ok {
+		v = &verification{verified: make(chan struct{}), created: time.Now()}
+		c.verifications[id] = v
+	}
+	return v
+}
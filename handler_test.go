@@ -0,0 +1,273 @@
+package twitchwh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSignedRequest creates a webhook request signed with the test client's secret.
+func newSignedRequest(messageID string, Type string, timestamp time.Time, body string) *http.Request {
+	r := httptest.NewRequest("POST", "/eventsub", strings.NewReader(body))
+	ts := timestamp.UTC().Format(time.RFC3339Nano)
+	r.Header.Set(twitchMessageID, messageID)
+	r.Header.Set(twitchMessageTimestamp, ts)
+	r.Header.Set(messageType, Type)
+	r.Header.Set(twitchMessageSignature, "sha256="+generateHmac("supersecretstring", messageID+ts+body))
+	return r
+}
+
+const testNotification = `{"subscription":{"id":"sub","type":"stream.online","version":"1"},"event":{}}`
+
+func TestHandlerInvalidSignature(t *testing.T) {
+	c := newTestClient()
+	r := newSignedRequest("1", messageTypeNotification, time.Now(), testNotification)
+	r.Header.Set(twitchMessageSignature, "sha256=invalid")
+	w := httptest.NewRecorder()
+	c.Handler(w, r)
+	if w.Code != 403 {
+		t.Fatalf("Expected 403, got %d", w.Code)
+	}
+}
+
+func TestHandlerLogging(t *testing.T) {
+	var output bytes.Buffer
+	c := newTestClient()
+	c.logger = slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	r := newSignedRequest("1", messageTypeNotification, time.Now(), testNotification)
+	r.Header.Set(twitchMessageSignature, "sha256=invalid")
+	c.Handler(httptest.NewRecorder(), r)
+	c.Handler(httptest.NewRecorder(), newSignedRequest("2", messageTypeRevocation, time.Now(), testNotification))
+	// Below the level of the logger
+	c.Handler(httptest.NewRecorder(), newSignedRequest("3", messageTypeNotification, time.Now(), testNotification))
+
+	var records []map[string]any
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var record map[string]any
+		err := decoder.Decode(&record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %v", records)
+	}
+	if records[0]["level"] != "WARN" || records[0]["message_id"] != "1" {
+		t.Fatalf("Unexpected signature failure record %v", records[0])
+	}
+	if records[1]["message_type"] != messageTypeRevocation || records[1]["subscription_id"] != "sub" || records[1]["subscription_type"] != "stream.online" {
+		t.Fatalf("Unexpected revocation record %v", records[1])
+	}
+}
+
+func TestHandlerStaleMessage(t *testing.T) {
+	now := time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC)
+	c := newTestClient()
+	c.clock = func() time.Time { return now }
+	var stale []StaleMessage
+	c.OnStaleMessage = func(message StaleMessage) {
+		stale = append(stale, message)
+	}
+
+	tests := []struct {
+		timestamp time.Time
+		status    int
+	}{
+		{now.Add(-time.Minute), 204},
+		{now.Add(-11 * time.Minute), 400},
+		{now.Add(11 * time.Minute), 400},
+	}
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+		c.Handler(w, newSignedRequest(strconv.Itoa(i), messageTypeNotification, test.timestamp, testNotification))
+		if w.Code != test.status {
+			t.Fatalf("Expected %d for timestamp %s, got %d", test.status, test.timestamp, w.Code)
+		}
+	}
+
+	if len(stale) != 2 {
+		t.Fatalf("Expected 2 stale messages, got %d", len(stale))
+	}
+	if stale[0].Age != 11*time.Minute || stale[1].Age != -11*time.Minute {
+		t.Fatalf("Unexpected ages %s and %s", stale[0].Age, stale[1].Age)
+	}
+}
+
+func TestHandlerReplayWithLongMaxMessageAge(t *testing.T) {
+	now := time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC)
+	c := newTestClient()
+	c.clock = func() time.Time { return now }
+	c.maxMessageAge = time.Hour
+	c.dedupStore = newDefaultDedupStore(c.maxMessageAge, c.clock)
+	handled := make(chan struct{}, 10)
+	c.On("stream.online", func(event json.RawMessage) {
+		handled <- struct{}{}
+	})
+
+	// Timestamped as far in the future as Handler accepts, so it can be replayed for two hours
+	timestamp := now.Add(time.Hour)
+	for _, elapsed := range []time.Duration{0, 30 * time.Minute, 119 * time.Minute} {
+		now = timestamp.Add(-time.Hour).Add(elapsed)
+		w := httptest.NewRecorder()
+		c.Handler(w, newSignedRequest("1", messageTypeNotification, timestamp, testNotification))
+		if w.Code != 204 {
+			t.Fatalf("Expected 204 after %s, got %d", elapsed, w.Code)
+		}
+	}
+
+	<-handled
+	select {
+	case <-handled:
+		t.Fatal("Replayed message was dispatched again")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandlerVerification(t *testing.T) {
+	c := newTestClient()
+	body := `{"challenge":"pogchamp","subscription":{"id":"sub","type":"stream.online","version":"1"}}`
+
+	// The challenge may arrive before AddSubscription knows the subscription ID
+	w := httptest.NewRecorder()
+	c.Handler(w, newSignedRequest("1", messageTypeVerification, time.Now(), body))
+	if w.Code != 200 || w.Body.String() != "pogchamp" {
+		t.Fatalf("Expected 200 pogchamp, got %d %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case <-c.awaitVerification("sub"):
+	default:
+		t.Fatal("Subscription was not marked as verified")
+	}
+	select {
+	case <-c.awaitVerification("other"):
+		t.Fatal("Unrelated subscription was marked as verified")
+	default:
+	}
+}
+
+func TestHandlerRegistration(t *testing.T) {
+	c := newTestClient()
+	calls := make(chan string, 10)
+	first := c.On("stream.online", func(event json.RawMessage) {
+		calls <- "first"
+	})
+	c.On("stream.online", func(event json.RawMessage) {
+		calls <- "second"
+	})
+	c.OnUnhandled(func(ctx context.Context, notification Notification) error {
+		calls <- "unhandled " + notification.Subscription.Type
+		return nil
+	})
+	expectCalls := func(expected ...string) {
+		t.Helper()
+		for _, call := range expected {
+			select {
+			case got := <-calls:
+				if got != call {
+					t.Fatalf("Expected %q, got %q", call, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected %q, got nothing", call)
+			}
+		}
+	}
+
+	c.Handler(httptest.NewRecorder(), newSignedRequest("1", messageTypeNotification, time.Now(), testNotification))
+	expectCalls("first", "second")
+
+	first.Remove()
+	first.Remove()
+	c.Handler(httptest.NewRecorder(), newSignedRequest("2", messageTypeNotification, time.Now(), testNotification))
+	expectCalls("second")
+
+	offline := `{"subscription":{"id":"sub","type":"stream.offline","version":"1"},"event":{}}`
+	c.Handler(httptest.NewRecorder(), newSignedRequest("3", messageTypeNotification, time.Now(), offline))
+	expectCalls("unhandled stream.offline")
+}
+
+func TestHandlerErrors(t *testing.T) {
+	c := newTestClient()
+	errs := make(chan error, 10)
+	c.OnError = func(err error) {
+		errs <- err
+	}
+	failed := errors.New("failed")
+	c.OnContext("stream.online", func(ctx context.Context, event json.RawMessage) error {
+		return failed
+	})
+	c.On("stream.online", func(event json.RawMessage) {
+		panic("oops")
+	})
+	handled := make(chan struct{}, 1)
+	c.On("stream.online", func(event json.RawMessage) {
+		handled <- struct{}{}
+	})
+
+	c.Handler(httptest.NewRecorder(), newSignedRequest("1", messageTypeNotification, time.Now(), testNotification))
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("Handler after the panicking one was not called")
+	}
+	err := <-errs
+	if !errors.Is(err, failed) {
+		t.Fatalf("Expected the error of the handler, got %v", err)
+	}
+	var handlerErr *HandlerError
+	if !errors.As(err, &handlerErr) || handlerErr.Type != "stream.online" || handlerErr.MessageID != "1" {
+		t.Fatalf("Expected a HandlerError, got %v", err)
+	}
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "oops" || len(panicErr.Stack) == 0 {
+		t.Fatalf("Expected a PanicError, got %v", err)
+	}
+
+	c.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, notification Notification) error {
+			panic("middleware")
+		}
+	})
+	c.Handler(httptest.NewRecorder(), newSignedRequest("2", messageTypeNotification, time.Now(), testNotification))
+	if err := <-errs; !errors.As(err, &panicErr) || panicErr.Value != "middleware" {
+		t.Fatalf("Expected a PanicError, got %v", err)
+	}
+
+	r := newSignedRequest("3", messageTypeNotification, time.Now(), testNotification)
+	r.Header.Set(twitchMessageSignature, "sha256=invalid")
+	c.Handler(httptest.NewRecorder(), r)
+	var signatureErr *InvalidSignatureError
+	if err := <-errs; !errors.As(err, &signatureErr) || signatureErr.MessageID != "3" {
+		t.Fatalf("Expected an InvalidSignatureError, got %v", err)
+	}
+}
+
+func TestHandlerPreviousSecret(t *testing.T) {
+	c := newTestClient()
+	c.webhookSecrets.rotate("newsupersecretstring")
+
+	// Signed with the previous secret
+	w := httptest.NewRecorder()
+	c.Handler(w, newSignedRequest("1", messageTypeNotification, time.Now(), testNotification))
+	if w.Code != 204 {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+
+	c.webhookSecrets.retire("newsupersecretstring")
+	w = httptest.NewRecorder()
+	c.Handler(w, newSignedRequest("2", messageTypeNotification, time.Now(), testNotification))
+	if w.Code != 403 {
+		t.Fatalf("Expected retired secret to be rejected with 403, got %d", w.Code)
+	}
+}
@@ -1,18 +1,122 @@
 package twitchwh
 
-import "net/http"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
 
 const helixURL = "https://api.twitch.tv/helix"
 
 // Interal generic request function that includes authorization headers.
 // TODO: Should this return the request rather than the response?
-func (c *Client) genericRequest(method string, endpoint string) (*http.Response, error) {
-	req, err := http.NewRequest(method, helixURL+endpoint, nil)
+func (c *Client) genericRequest(ctx context.Context, method string, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.helixURL+endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	err = c.authorize(req, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(req, true)
+}
+
+// Sets the Client-ID and Authorization headers of req. Uses the app access token from the TokenProvider if token is empty.
+func (c *Client) authorize(req *http.Request, token string) error {
+	if token == "" {
+		var err error
+		token, err = c.tokens.Token(req.Context())
+		if err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Client-ID", c.clientID)
+	return nil
+}
+
+// Internal request function for JSON endpoints.
+// Serializes reqBody (if not nil) as the request body, and parses the response into resBody (if not nil).
+// Returns [UnauthorizedError] for 401, and [UnhandledStatusError] for any status other than expectedStatus.
+func (c *Client) jsonRequest(ctx context.Context, method string, endpoint string, reqBody any, expectedStatus int, resBody any) error {
+	var reader io.Reader
+	if reqBody != nil {
+		data, err := json.Marshal(reqBody)
+		if err != nil {
+			return &InternalError{"Could not serialize request body to JSON", err}
+		}
+		reader = bytes.NewReader(data)
+	}
 
-	return c.httpClient.Do(req)
+	req, err := http.NewRequestWithContext(ctx, method, c.helixURL+endpoint, reader)
+	if err != nil {
+		return &InternalError{"Could not create request", err}
+	}
+	err = c.authorize(req, "")
+	if err != nil {
+		return err
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.do(req, true)
+	if err != nil {
+		return &InternalError{"Could not send request", err}
+	}
+
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return &InternalError{"Could not read response body", err}
+	}
+
+	if res.StatusCode == 401 {
+		return &UnauthorizedError{}
+	}
+	if res.StatusCode != expectedStatus {
+		return &UnhandledStatusError{res.StatusCode, body}
+	}
+
+	if resBody != nil {
+		err = json.Unmarshal(body, resBody)
+		if err != nil {
+			return &InternalError{"Could not parse response body", err}
+		}
+	}
+	return nil
+}
+
+// Internal function that runs request, and runs it again with a new token if it returned [UnauthorizedError].
+func (c *Client) retryUnauthorized(ctx context.Context, request func() error) error {
+	rejected, err := c.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	err = request()
+	var uaErr *UnauthorizedError
+	if errors.As(err, &uaErr) {
+		err := c.refreshToken(ctx, rejected)
+		if err != nil {
+			return err
+		}
+		return request()
+	}
+	return err
+}
+
+// Replaces the app access token after rejected was rejected by Twitch.
+func (c *Client) refreshToken(ctx context.Context, rejected string) error {
+	c.logger.Info("Token invalid, refreshing")
+	_, err := c.tokens.Refresh(ctx, rejected)
+	if err != nil {
+		return err
+	}
+	c.metrics.TokenRefresh("app")
+	return nil
 }
@@ -0,0 +1,49 @@
+package twitchwh
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Returns the logger used when ClientConfig.Logger is not set.
+// Debug output goes to stdout. Otherwise, warnings and errors go to the default slog logger.
+func defaultLogger(debug bool) *slog.Logger {
+	if debug {
+		return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})).With("component", "twitchwh")
+	}
+	return slog.New(&levelHandler{slog.Default().Handler(), slog.LevelWarn}).With("component", "twitchwh")
+}
+
+// Returns a logger that discards everything
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// levelHandler drops records below level, on top of the level of the wrapped handler.
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// Returns log attributes identifying a subscription
+func subscriptionAttrs(subscription Subscription) []any {
+	attrs := []any{"subscription_id", subscription.ID, "subscription_type", subscription.Type}
+	if subscription.Condition.BroadcasterUserID != "" {
+		attrs = append(attrs, "broadcaster_user_id", subscription.Condition.BroadcasterUserID)
+	}
+	return attrs
+}
@@ -0,0 +1,246 @@
+package twitchwh
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements from the client. Implementations must be safe for concurrent use.
+// Set ClientConfig.Metrics to collect them, eg. with [PrometheusMetrics].
+type Metrics interface {
+	// A notification was received and dispatched (duplicates are not counted)
+	Notification(Type string)
+	// A webhook_callback_verification challenge was answered
+	VerificationChallenge()
+	// A subscription was revoked. reason is the status of the subscription, eg. user_removed
+	Revocation(reason string)
+	// A webhook request was rejected because of an invalid signature
+	SignatureFailure()
+	// A notification was ignored because its message ID was already handled
+	DuplicateDelivery(Type string)
+	// A notification was dropped, or rejected with 503 Service Unavailable, because the dispatch queue was full
+	EventDropped(Type string)
+	// A handler for the event type returned after running for d
+	HandlerDuration(Type string, d time.Duration)
+	// A handler for the event type panicked
+	HandlerPanic(Type string)
+	// A Helix request was sent. status is 0 if no response was received
+	HelixRequest(method string, path string, status int, d time.Duration)
+	// A token was refreshed. token is "app" or "user"
+	TokenRefresh(token string)
+}
+
+// Used when ClientConfig.Metrics is not set
+type nopMetrics struct{}
+
+func (nopMetrics) Notification(string)                             {}
+func (nopMetrics) VerificationChallenge()                          {}
+func (nopMetrics) Revocation(string)                               {}
+func (nopMetrics) SignatureFailure()                               {}
+func (nopMetrics) DuplicateDelivery(string)                        {}
+func (nopMetrics) EventDropped(string)                             {}
+func (nopMetrics) HandlerDuration(string, time.Duration)           {}
+func (nopMetrics) HandlerPanic(string)                             {}
+func (nopMetrics) HelixRequest(string, string, int, time.Duration) {}
+func (nopMetrics) TokenRefresh(string)                             {}
+
+// Histogram buckets in seconds, the same as the default buckets of the Prometheus client
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics is a [Metrics] implementation that serves the measurements in the Prometheus text format.
+// It does not depend on the Prometheus client library.
+//
+//	metrics := twitchwh.NewPrometheusMetrics()
+//	client, _ := twitchwh.New(twitchwh.ClientConfig{
+//		// ...
+//		Metrics: metrics,
+//	})
+//	http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+type histogram struct {
+	// Cumulative count per bucket of durationBuckets
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type metricFamily struct {
+	name string
+	help string
+	kind string
+}
+
+// Every metric, in the order they are served
+var metricFamilies = []metricFamily{
+	{"twitchwh_notifications_total", "Notifications dispatched, by subscription type.", "counter"},
+	{"twitchwh_verification_challenges_total", "Webhook verification challenges answered.", "counter"},
+	{"twitchwh_revocations_total", "Subscriptions revoked, by reason.", "counter"},
+	{"twitchwh_signature_failures_total", "Webhook requests rejected because of an invalid signature.", "counter"},
+	{"twitchwh_duplicate_deliveries_total", "Notifications ignored because they were already handled, by subscription type.", "counter"},
+	{"twitchwh_events_dropped_total", "Notifications dropped or rejected because the dispatch queue was full, by subscription type.", "counter"},
+	{"twitchwh_handler_duration_seconds", "Time spent in event handlers, by subscription type.", "histogram"},
+	{"twitchwh_handler_panics_total", "Event handlers that panicked, by subscription type.", "counter"},
+	{"twitchwh_helix_request_duration_seconds", "Helix request latency, by method, path, and status.", "histogram"},
+	{"twitchwh_token_refreshes_total", "Tokens refreshed, by token kind.", "counter"},
+}
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		counters:   make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+	// Counters without labels are served from the start
+	m.counters["twitchwh_verification_challenges_total"] = map[string]float64{"": 0}
+	m.counters["twitchwh_signature_failures_total"] = map[string]float64{"": 0}
+	return m
+}
+
+func (m *PrometheusMetrics) Notification(Type string) {
+	m.inc("twitchwh_notifications_total", labels("type", Type))
+}
+
+func (m *PrometheusMetrics) VerificationChallenge() {
+	m.inc("twitchwh_verification_challenges_total", "")
+}
+
+func (m *PrometheusMetrics) Revocation(reason string) {
+	m.inc("twitchwh_revocations_total", labels("reason", reason))
+}
+
+func (m *PrometheusMetrics) SignatureFailure() {
+	m.inc("twitchwh_signature_failures_total", "")
+}
+
+func (m *PrometheusMetrics) DuplicateDelivery(Type string) {
+	m.inc("twitchwh_duplicate_deliveries_total", labels("type", Type))
+}
+
+func (m *PrometheusMetrics) EventDropped(Type string) {
+	m.inc("twitchwh_events_dropped_total", labels("type", Type))
+}
+
+func (m *PrometheusMetrics) HandlerDuration(Type string, d time.Duration) {
+	m.observe("twitchwh_handler_duration_seconds", labels("type", Type), d)
+}
+
+func (m *PrometheusMetrics) HandlerPanic(Type string) {
+	m.inc("twitchwh_handler_panics_total", labels("type", Type))
+}
+
+func (m *PrometheusMetrics) HelixRequest(method string, path string, status int, d time.Duration) {
+	m.observe("twitchwh_helix_request_duration_seconds", labels("method", method, "path", path, "status", strconv.Itoa(status)), d)
+}
+
+func (m *PrometheusMetrics) TokenRefresh(token string) {
+	m.inc("twitchwh_token_refreshes_total", labels("token", token))
+}
+
+func (m *PrometheusMetrics) inc(name string, labels string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]float64)
+	}
+	m.counters[name][labels]++
+}
+
+func (m *PrometheusMetrics) observe(name string, labels string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.histograms[name] == nil {
+		m.histograms[name] = make(map[string]*histogram)
+	}
+	h, ok := m.histograms[name][labels]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		m.histograms[name][labels] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	for _, family := range metricFamilies {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		if family.kind == "counter" {
+			series := m.counters[family.name]
+			for _, labels := range sortedKeys(series) {
+				fmt.Fprintf(&b, "%s%s %s\n", family.name, braces(labels), formatFloat(series[labels]))
+			}
+			continue
+		}
+		series := m.histograms[family.name]
+		for _, labels := range sortedKeys(series) {
+			h := series[labels]
+			for i, bound := range durationBuckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", family.name, braces(joinLabels(labels, `le="`+formatFloat(bound)+`"`)), h.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", family.name, braces(joinLabels(labels, `le="+Inf"`)), h.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", family.name, braces(labels), formatFloat(h.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", family.name, braces(labels), h.count)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// Renders label name and value pairs, eg. `type="stream.online"`
+func labels(pairs ...string) string {
+	var rendered []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rendered = append(rendered, pairs[i]+`="`+labelValueEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return strings.Join(rendered, ",")
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func joinLabels(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package twitchwh
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	c := newTestClient()
+	c.metrics = metrics
+	handled := make(chan struct{})
+	c.On("stream.online", func(event json.RawMessage) {
+		close(handled)
+	})
+
+	c.Handler(httptest.NewRecorder(), newSignedRequest("1", messageTypeNotification, time.Now(), testNotification))
+	c.Handler(httptest.NewRecorder(), newSignedRequest("1", messageTypeNotification, time.Now(), testNotification))
+	c.Handler(httptest.NewRecorder(), newSignedRequest("2", messageTypeVerification, time.Now(), `{"challenge":"pogchamp","subscription":{"id":"sub"}}`))
+	c.Handler(httptest.NewRecorder(), newSignedRequest("3", messageTypeRevocation, time.Now(), `{"subscription":{"id":"sub","status":"user_removed"}}`))
+	invalid := newSignedRequest("4", messageTypeNotification, time.Now(), testNotification)
+	invalid.Header.Set(twitchMessageSignature, "sha256=invalid")
+	c.Handler(httptest.NewRecorder(), invalid)
+	metrics.HelixRequest("GET", "/helix/eventsub/subscriptions", 200, 30*time.Millisecond)
+	metrics.TokenRefresh(`app "quoted"`)
+	<-handled
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	for _, line := range []string{
+		"# TYPE twitchwh_notifications_total counter",
+		`twitchwh_notifications_total{type="stream.online"} 1`,
+		`twitchwh_duplicate_deliveries_total{type="stream.online"} 1`,
+		"twitchwh_verification_challenges_total 1",
+		`twitchwh_revocations_total{reason="user_removed"} 1`,
+		"twitchwh_signature_failures_total 1",
+		`twitchwh_helix_request_duration_seconds_bucket{method="GET",path="/helix/eventsub/subscriptions",status="200",le="0.025"} 0`,
+		`twitchwh_helix_request_duration_seconds_bucket{method="GET",path="/helix/eventsub/subscriptions",status="200",le="0.05"} 1`,
+		`twitchwh_helix_request_duration_seconds_count{method="GET",path="/helix/eventsub/subscriptions",status="200"} 1`,
+		`twitchwh_token_refreshes_total{token="app \"quoted\""} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Missing line %q", line)
+		}
+	}
+	if t.Failed() {
+		t.Log(string(body))
+	}
+
+	// The handler duration is recorded after the handler returns
+	deadline := time.Now().Add(time.Second)
+	for {
+		w := httptest.NewRecorder()
+		metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if strings.Contains(w.Body.String(), `twitchwh_handler_duration_seconds_count{type="stream.online"} 1`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Handler duration was not recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
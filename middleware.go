@@ -0,0 +1,54 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Notification is an event received from Twitch, along with its metadata.
+type Notification struct {
+	// Unique ID of the message. Retried deliveries of the same event have the same ID.
+	MessageID string
+	// When Twitch sent the message. Zero if the timestamp is missing or invalid
+	Timestamp time.Time
+	// The subscription the event was sent for. Transport.Method is either "webhook" or "websocket".
+	Subscription Subscription
+	// Event body
+	Event json.RawMessage
+}
+
+// HandlerFunc handles a notification. See [Middleware].
+// The returned error is passed to Client.OnError.
+type HandlerFunc func(ctx context.Context, notification Notification) error
+
+// Middleware wraps the dispatch of notifications. It is called with the next HandlerFunc in the chain,
+// and returns a HandlerFunc that calls it. Returning without calling next skips the rest of the chain,
+// including the handlers assigned to the event type. Errors of the handlers are returned by next, as *HandlerError.
+//
+//	client.Use(func(next twitchwh.HandlerFunc) twitchwh.HandlerFunc {
+//		return func(ctx context.Context, notification twitchwh.Notification) error {
+//			start := time.Now()
+//			err := next(ctx, notification)
+//			log.Printf("Handled %s in %s", notification.Subscription.Type, time.Since(start))
+//			return err
+//		}
+//	})
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use adds middleware around the dispatch of every notification, received over the webhook or WebSocket transport.
+// Middleware runs in the order it was added, the first being the outermost. It runs in the goroutine of the handler,
+// including for events that do not have a handler assigned.
+//
+// Use is not safe to call while events are being received.
+func (c *Client) Use(middleware ...Middleware) {
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// chain wraps the handler in the middleware of the client.
+func (c *Client) chain(handler HandlerFunc) HandlerFunc {
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		handler = c.middleware[i](handler)
+	}
+	return handler
+}
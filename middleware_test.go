@@ -0,0 +1,66 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	c := newTestClient()
+	calls := make(chan string, 10)
+	c.On("stream.online", func(event json.RawMessage) {
+		calls <- "handler"
+	})
+	c.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, notification Notification) error {
+			calls <- "outer " + notification.MessageID
+			return next(ctx, notification)
+		}
+	}, func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, notification Notification) error {
+			if notification.MessageID == "2" {
+				calls <- "skipped"
+				return nil
+			}
+			calls <- "inner " + notification.Subscription.ID
+			return next(ctx, notification)
+		}
+	})
+
+	timestamp := time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC)
+	c.clock = func() time.Time { return timestamp }
+	var received Notification
+	c.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, notification Notification) error {
+			received = notification
+			return next(ctx, notification)
+		}
+	})
+
+	expected := []string{"outer 1", "inner sub", "handler"}
+	c.Handler(httptest.NewRecorder(), newSignedRequest("1", messageTypeNotification, timestamp, testNotification))
+	for _, call := range expected {
+		if got := <-calls; got != call {
+			t.Fatalf("Expected %q, got %q", call, got)
+		}
+	}
+	if !received.Timestamp.Equal(timestamp) || received.Subscription.Type != "stream.online" || string(received.Event) != "{}" {
+		t.Fatalf("Unexpected notification %+v", received)
+	}
+
+	expected = []string{"outer 2", "skipped"}
+	c.Handler(httptest.NewRecorder(), newSignedRequest("2", messageTypeNotification, timestamp, testNotification))
+	for _, call := range expected {
+		if got := <-calls; got != call {
+			t.Fatalf("Expected %q, got %q", call, got)
+		}
+	}
+	select {
+	case got := <-calls:
+		t.Fatalf("Handler was called after short-circuit: %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,95 @@
+package twitchwh
+
+import (
+	"context"
+	"sync"
+)
+
+// SubscriptionQuota is the number of subscriptions created by the client and their total cost, as reported by Helix.
+// See: https://dev.twitch.tv/docs/eventsub/manage-subscriptions/#subscription-limits
+type SubscriptionQuota struct {
+	// Number of subscriptions, including failed ones
+	Total int `json:"total"`
+	// Sum of the cost of enabled and pending subscriptions
+	TotalCost int `json:"total_cost"`
+	// Maximum total cost allowed. Zero if not known yet.
+	MaxTotalCost int `json:"max_total_cost"`
+}
+
+// Keeps track of the quota from Helix responses, so that creating a subscription can fail before hitting the cap.
+type quotaTracker struct {
+	mu    sync.Mutex
+	quota SubscriptionQuota
+	// Cost of the subscriptions counted in quota.TotalCost, by ID
+	costs map[string]int
+}
+
+// Quota returns the subscription quota as of the latest Helix response.
+// It is updated whenever subscriptions are fetched, created, or removed by this client.
+// Subscriptions that fail or are revoked are only taken into account on the next fetch, see [Client.GetSubscriptionQuota].
+func (c *Client) Quota() SubscriptionQuota {
+	c.quota.mu.Lock()
+	defer c.quota.mu.Unlock()
+	return c.quota.quota
+}
+
+// GetSubscriptionQuota fetches the current subscription quota from Helix.
+func (c *Client) GetSubscriptionQuota(ctx context.Context) (SubscriptionQuota, error) {
+	var responseBody struct {
+		SubscriptionQuota
+		Data []Subscription `json:"data"`
+	}
+	err := c.retryUnauthorized(ctx, func() error {
+		return c.jsonRequest(ctx, "GET", "/eventsub/subscriptions", nil, 200, &responseBody)
+	})
+	if err != nil {
+		return SubscriptionQuota{}, err
+	}
+	c.quota.update(responseBody.SubscriptionQuota, responseBody.Data...)
+	return responseBody.SubscriptionQuota, nil
+}
+
+// update replaces the quota with one returned by Helix, and remembers the cost of subs.
+func (q *quotaTracker) update(quota SubscriptionQuota, subs ...Subscription) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.quota = quota
+	if q.costs == nil {
+		q.costs = make(map[string]int)
+	}
+	for _, sub := range subs {
+		if subscriptionActive(sub) {
+			q.costs[sub.ID] = sub.Cost
+		} else {
+			delete(q.costs, sub.ID)
+		}
+	}
+}
+
+// removed updates the quota after the subscription with the given ID was removed.
+func (q *quotaTracker) removed(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.quota.Total > 0 {
+		q.quota.Total--
+	}
+	if cost, ok := q.costs[id]; ok {
+		q.quota.TotalCost -= cost
+		delete(q.costs, id)
+	}
+}
+
+// check returns [QuotaExceededError] if the max total cost has been reached, so that a subscription of the given type
+// that costs 1 can not be created. Types that need user authorization are not checked, since they cost nothing once
+// the user has authorized the app, and Helix still accepts them at the cap.
+func (q *quotaTracker) check(Type string) error {
+	if _, ok := subscriptionScopes[Type]; ok {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.quota.MaxTotalCost > 0 && q.quota.TotalCost >= q.quota.MaxTotalCost {
+		return &QuotaExceededError{Type: Type, Quota: q.quota}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package twitchwh
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMaxRetries = 3
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// Upper bound for a single backoff delay
+const maxRetryBackoff = 30 * time.Second
+
+// RateLimit is the state of the Helix rate limit bucket of the app access token.
+// See: https://dev.twitch.tv/docs/api/guide/#twitch-rate-limits
+type RateLimit struct {
+	// Size of the bucket in points. Zero until the first Helix response.
+	Limit int
+	// Points left in the bucket
+	Remaining int
+	// When the bucket is refilled
+	Reset time.Time
+}
+
+type rateLimiter struct {
+	mu    sync.Mutex
+	state RateLimit
+}
+
+// RateLimit returns the rate limit state of the app access token, as of the latest Helix response.
+func (c *Client) RateLimit() RateLimit {
+	c.rateLimiter.mu.Lock()
+	defer c.rateLimiter.mu.Unlock()
+	return c.rateLimiter.state
+}
+
+// reserve takes a point from the bucket, and returns how long to wait before sending the request if the bucket is empty.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state.Limit == 0 {
+		// Nothing known about the bucket yet
+		return 0
+	}
+	if l.state.Remaining > 0 {
+		l.state.Remaining--
+		return 0
+	}
+	if now.Before(l.state.Reset) {
+		return l.state.Reset.Sub(now)
+	}
+	return 0
+}
+
+// update sets the bucket state from the Ratelimit headers of a Helix response.
+func (l *rateLimiter) update(header http.Header) {
+	limit, err := strconv.Atoi(header.Get("Ratelimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+	}
+}
+
+// do sends a Helix request. Every Helix request goes through here.
+// appToken is whether the request is authorized with the app access token, which the rate limit state is tracked for.
+//
+// Requests made with the app access token wait for the bucket to refill if it is empty.
+// Requests are retried up to ClientConfig.MaxRetries times after a 429 response, and, for idempotent
+// methods, after a 5xx response or network error. Retries wait for a jittered exponential backoff,
+// or until the bucket is refilled for 429 responses.
+//
+// The request is traced in a single client span, including retries.
+func (c *Client) do(req *http.Request, appToken bool) (res *http.Response, err error) {
+	ctx, span := startRequestSpan(c.tracer, req)
+	req = req.WithContext(ctx)
+	defer func() {
+		endRequestSpan(span, res, err)
+		span.End()
+	}()
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			span.SetAttributes(attrRetryCount.Int(attempt))
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		if appToken {
+			if wait := c.rateLimiter.reserve(time.Now()); wait > 0 {
+				c.logger.Warn("Rate limit exhausted, waiting for reset", "wait", wait)
+				err := c.sleep(ctx, wait)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		start := time.Now()
+		res, err := c.httpClient.Do(req)
+		retry := attempt < c.maxRetries && (req.Body == nil || req.GetBody != nil)
+		if err != nil {
+			c.metrics.HelixRequest(req.Method, req.URL.Path, 0, time.Since(start))
+			if !retry || !idempotent(req.Method) || ctx.Err() != nil {
+				return nil, err
+			}
+			c.logger.Warn("Helix request failed, retrying", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1, "error", err)
+			err = c.sleep(ctx, c.backoff(attempt))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		latency := time.Since(start)
+		c.logger.Debug("Helix request", "method", req.Method, "path", req.URL.Path, "status", res.StatusCode, "latency", latency)
+		c.metrics.HelixRequest(req.Method, req.URL.Path, res.StatusCode, latency)
+		if appToken {
+			c.rateLimiter.update(res.Header)
+		}
+
+		var wait time.Duration
+		switch {
+		case res.StatusCode == 429:
+			wait = c.backoff(attempt)
+			if reset, err := strconv.ParseInt(res.Header.Get("Ratelimit-Reset"), 10, 64); err == nil {
+				untilReset := time.Until(time.Unix(reset, 0))
+				if appToken && untilReset > 0 {
+					// The bucket is empty, so the next attempt waits for the reset
+					wait = 0
+				} else if untilReset > wait {
+					wait = untilReset
+				}
+			}
+		case res.StatusCode >= 500 && idempotent(req.Method):
+			wait = c.backoff(attempt)
+		default:
+			return res, nil
+		}
+		if !retry {
+			return res, nil
+		}
+
+		c.logger.Warn("Helix request failed, retrying", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1, "status", res.StatusCode)
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if wait > 0 {
+			err = c.sleep(ctx, wait)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// backoff returns a random delay between 0 and RetryBackoff * 2^attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	max := c.retryBackoff << attempt
+	if max <= 0 || max > maxRetryBackoff {
+		max = maxRetryBackoff
+	}
+	return rand.N(max) + 1
+}
+
+func idempotent(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "DELETE"
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+package twitchwh
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRateLimitTestClient returns a client that records how long it would have slept instead of sleeping.
+func newRateLimitTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *[]time.Duration) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c := newTestClient()
+	c.helixURL = server.URL
+	var sleeps []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	return c, &sleeps
+}
+
+func setRateLimit(w http.ResponseWriter, remaining int, reset time.Time) {
+	w.Header().Set("Ratelimit-Limit", "800")
+	w.Header().Set("Ratelimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("Ratelimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
+
+func TestRetryTooManyRequests(t *testing.T) {
+	reset := time.Now().Add(time.Minute)
+	var requests atomic.Int32
+	c, sleeps := newRateLimitTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			setRateLimit(w, 0, reset)
+			w.WriteHeader(429)
+			return
+		}
+		setRateLimit(w, 799, reset)
+		w.Write([]byte(`{}`))
+	})
+
+	err := c.jsonRequest(context.Background(), "POST", "/eventsub/subscriptions", map[string]string{}, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("Expected 2 requests, got %d", requests.Load())
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] < 50*time.Second {
+		t.Fatalf("Expected to wait for the reset, waited %v", *sleeps)
+	}
+	if limit := c.RateLimit(); limit.Limit != 800 || limit.Remaining != 799 || limit.Reset.Unix() != reset.Unix() {
+		t.Fatalf("Unexpected rate limit %+v", limit)
+	}
+}
+
+func TestRateLimitExhausted(t *testing.T) {
+	reset := time.Now().Add(time.Minute)
+	c, sleeps := newRateLimitTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		setRateLimit(w, 0, reset)
+		w.Write([]byte(`{}`))
+	})
+
+	for i := 0; i < 2; i++ {
+		err := c.jsonRequest(context.Background(), "GET", "/eventsub/subscriptions", nil, 200, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] < 50*time.Second {
+		t.Fatalf("Expected the second request to wait for the reset, waited %v", *sleeps)
+	}
+}
+
+func TestRetryServerError(t *testing.T) {
+	var requests atomic.Int32
+	c, sleeps := newRateLimitTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	err := c.jsonRequest(context.Background(), "GET", "/eventsub/subscriptions", nil, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*sleeps) != 2 {
+		t.Fatalf("Expected 2 retries, got %d", len(*sleeps))
+	}
+	for i, d := range *sleeps {
+		if d <= 0 || d > defaultRetryBackoff<<i {
+			t.Fatalf("Backoff %d out of range: %s", i, d)
+		}
+	}
+
+	// POST is not idempotent, so it is not retried after a 5xx
+	requests.Store(0)
+	err = c.jsonRequest(context.Background(), "POST", "/eventsub/subscriptions", map[string]string{}, 200, nil)
+	if status, ok := err.(*UnhandledStatusError); !ok || status.Status != 503 {
+		t.Fatalf("Expected UnhandledStatusError 503, got %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Expected 1 request, got %d", requests.Load())
+	}
+}
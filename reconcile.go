@@ -0,0 +1,149 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SubscriptionSpec describes a webhook subscription that should exist. Used by [Client.Reconcile].
+type SubscriptionSpec struct {
+	Type      string
+	Version   string
+	Condition Condition
+	// Callback URL of the subscription. Defaults to ClientConfig.WebhookURL
+	Callback string
+}
+
+// ReconcileOptions is used to configure [Client.Reconcile]
+type ReconcileOptions struct {
+	// Only report the changes that would be made, without making them.
+	DryRun bool
+}
+
+// ReconcileReport lists the changes made by [Client.Reconcile].
+// In dry run mode, it lists the changes that would have been made.
+type ReconcileReport struct {
+	DryRun bool
+	// Existing subscriptions that match a spec and were left alone
+	Kept []Subscription
+	// Specs that did not have a matching subscription and were created
+	Created []SubscriptionSpec
+	// Subscriptions that did not match any spec, were duplicates, or had failed, and were removed
+	Removed []Subscription
+}
+
+// Reconcile makes the webhook subscriptions of the client match the desired set.
+//
+// Subscriptions are matched on type, version, condition, and callback. Missing subscriptions are created,
+// and subscriptions that are not desired are removed. Subscriptions that have failed (eg. failed verification
+// or were revoked) are removed, and recreated if they are still desired. Subscriptions using other transports
+// (WebSocket and conduits) are never touched.
+//
+// Reconcile keeps going when a single change fails. The report lists the changes that succeeded,
+// and the returned error joins every failure.
+//
+//	report, err := client.Reconcile(ctx, []twitchwh.SubscriptionSpec{
+//		{Type: "stream.online", Version: "1", Condition: twitchwh.Condition{BroadcasterUserID: "215185844"}},
+//	}, twitchwh.ReconcileOptions{DryRun: true})
+func (c *Client) Reconcile(ctx context.Context, desired []SubscriptionSpec, options ReconcileOptions) (ReconcileReport, error) {
+	report := ReconcileReport{DryRun: options.DryRun}
+
+	existing, err := c.fetchSubscriptions(ctx, "")
+	if err != nil {
+		return report, err
+	}
+
+	// Existing webhook subscriptions that can be kept, by spec key
+	kept := make(map[string]Subscription)
+	var keptKeys []string
+	var remove []Subscription
+	for _, sub := range existing {
+		if sub.Transport.Method != "webhook" {
+			continue
+		}
+		key := specKey(SubscriptionSpec{sub.Type, sub.Version, sub.Condition, sub.Transport.Callback})
+		if !subscriptionActive(sub) {
+			remove = append(remove, sub)
+			continue
+		}
+		if _, ok := kept[key]; ok {
+			// Duplicate of a subscription that is already kept
+			remove = append(remove, sub)
+			continue
+		}
+		kept[key] = sub
+		keptKeys = append(keptKeys, key)
+	}
+
+	wanted := make(map[string]bool)
+	var create []SubscriptionSpec
+	for _, spec := range desired {
+		if spec.Callback == "" {
+			spec.Callback = c.webhookURL
+		}
+		key := specKey(spec)
+		if wanted[key] {
+			continue
+		}
+		wanted[key] = true
+		if sub, ok := kept[key]; ok {
+			report.Kept = append(report.Kept, sub)
+		} else {
+			create = append(create, spec)
+		}
+	}
+	for _, key := range keptKeys {
+		if !wanted[key] {
+			remove = append(remove, kept[key])
+		}
+	}
+
+	if options.DryRun {
+		report.Created = create
+		report.Removed = remove
+		return report, nil
+	}
+
+	// Remove first, so that failed subscriptions do not conflict with their replacements
+	var errs []error
+	for _, sub := range remove {
+		c.logger.Info("Reconcile: removing subscription", subscriptionAttrs(sub)...)
+		err := c.RemoveSubscriptionContext(ctx, sub.ID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Removed = append(report.Removed, sub)
+	}
+	for _, spec := range create {
+		c.logger.Info("Reconcile: creating subscription", "subscription_type", spec.Type)
+		err := c.retryUnauthorized(ctx, func() error {
+			_, err := c.addWebhookSubscription(ctx, spec.Callback, spec.Type, spec.Version, spec.Condition)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Created = append(report.Created, spec)
+	}
+	return report, errors.Join(errs...)
+}
+
+// Subscriptions in any other status have failed, and will never receive events again.
+func subscriptionActive(sub Subscription) bool {
+	return sub.Status == "enabled" || sub.Status == "webhook_callback_verification_pending"
+}
+
+// specKey identifies a subscription by its type, version, condition, and callback.
+// RewardID is compared as a string, since Helix returns it as a string even if it was created as an int.
+// The query parameter added to callbacks by RotateWebhookSecret is ignored.
+func specKey(spec SubscriptionSpec) string {
+	if spec.Condition.RewardID != nil {
+		spec.Condition.RewardID = fmt.Sprint(spec.Condition.RewardID)
+	}
+	condition, _ := json.Marshal(spec.Condition)
+	return spec.Type + "\x00" + spec.Version + "\x00" + string(condition) + "\x00" + withoutSecretID(spec.Callback)
+}
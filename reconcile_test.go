@@ -0,0 +1,120 @@
+package twitchwh_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/LinneB/twitchwh"
+	"github.com/LinneB/twitchwh/twitchwhtest"
+)
+
+func TestReconcile(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:      "id",
+		ClientSecret:  "secret",
+		WebhookSecret: "supersecretstring",
+		WebhookURL:    "https://example.com/eventsub",
+		HelixURL:      server.HelixURL(),
+		OAuthURL:      server.OAuthURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Webhook = http.HandlerFunc(client.Handler)
+
+	online := twitchwh.SubscriptionSpec{Type: "stream.online", Version: "1", Condition: twitchwh.Condition{BroadcasterUserID: "1"}}
+	offline := twitchwh.SubscriptionSpec{Type: "stream.offline", Version: "1", Condition: twitchwh.Condition{BroadcasterUserID: "1"}}
+	update := twitchwh.SubscriptionSpec{Type: "channel.update", Version: "2", Condition: twitchwh.Condition{BroadcasterUserID: "1"}}
+	for _, spec := range []twitchwh.SubscriptionSpec{online, offline, update} {
+		err := client.AddSubscription(spec.Type, spec.Version, spec.Condition)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// channel.update is desired but revoked, so it has to be recreated
+	server.Revoke(server.Subscriptions()[2].ID, "authorization_revoked")
+
+	desired := []twitchwh.SubscriptionSpec{
+		online,
+		update,
+		{Type: "channel.follow", Version: "2", Condition: twitchwh.Condition{BroadcasterUserID: "1", ModeratorUserID: "1"}},
+	}
+
+	plan, err := client.Reconcile(context.Background(), desired, twitchwh.ReconcileOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Kept) != 1 || len(plan.Created) != 2 || len(plan.Removed) != 2 {
+		t.Fatalf("Unexpected plan: %+v", plan)
+	}
+	if len(server.Subscriptions()) != 3 {
+		t.Fatal("Dry run made changes")
+	}
+
+	report, err := client.Reconcile(context.Background(), desired, twitchwh.ReconcileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Kept) != 1 || len(report.Created) != 2 || len(report.Removed) != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	types := map[string]bool{}
+	for _, sub := range server.Subscriptions() {
+		types[sub.Type] = true
+	}
+	if len(types) != 3 || !types["stream.online"] || !types["channel.update"] || !types["channel.follow"] {
+		t.Fatalf("Unexpected subscriptions after reconcile: %v", types)
+	}
+
+	// Nothing left to do
+	report, err = client.Reconcile(context.Background(), desired, twitchwh.ReconcileOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 0 || len(report.Removed) != 0 {
+		t.Fatalf("Expected no changes, got %+v", report)
+	}
+}
+
+func TestReconcileRewardID(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:      "id",
+		ClientSecret:  "secret",
+		WebhookSecret: "supersecretstring",
+		WebhookURL:    "https://example.com/eventsub",
+		HelixURL:      server.HelixURL(),
+		OAuthURL:      server.OAuthURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Webhook = http.HandlerFunc(client.Handler)
+
+	// Created with an int reward ID, which Helix returns as a string
+	redemption := twitchwh.SubscriptionSpec{
+		Type:      "channel.channel_points_custom_reward_redemption.add",
+		Version:   "1",
+		Condition: twitchwh.Condition{BroadcasterUserID: "1", RewardID: 123},
+	}
+	err = client.AddSubscription(redemption.Type, redemption.Version, redemption.Condition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rewardID := range []any{123, "123"} {
+		redemption.Condition.RewardID = rewardID
+		plan, err := client.Reconcile(context.Background(), []twitchwh.SubscriptionSpec{redemption}, twitchwh.ReconcileOptions{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Kept) != 1 || len(plan.Created) != 0 || len(plan.Removed) != 0 {
+			t.Fatalf("Expected reward ID %#v to match the existing subscription, got %+v", rewardID, plan)
+		}
+	}
+}
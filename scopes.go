@@ -0,0 +1,103 @@
+package twitchwh
+
+import "slices"
+
+// Authorization needed to create a subscription of a particular type.
+// See: https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/
+type scopeRequirement struct {
+	// Returns the ID of the user that must have authorized the app
+	user func(Condition) string
+	// The user must have granted at least one of these scopes
+	anyOf []string
+	// The user must also have granted every one of these scopes if the subscription is created with the
+	// app access token, which is used for the webhook and conduit transports
+	appTokenAllOf []string
+}
+
+func conditionBroadcaster(condition Condition) string { return condition.BroadcasterUserID }
+func conditionModerator(condition Condition) string   { return condition.ModeratorUserID }
+func conditionUser(condition Condition) string        { return condition.UserID }
+
+var subscriptionScopes = map[string]scopeRequirement{
+	"channel.follow":               {user: conditionModerator, anyOf: []string{"moderator:read:followers"}},
+	"channel.subscribe":            {user: conditionBroadcaster, anyOf: []string{"channel:read:subscriptions"}},
+	"channel.subscription.end":     {user: conditionBroadcaster, anyOf: []string{"channel:read:subscriptions"}},
+	"channel.subscription.gift":    {user: conditionBroadcaster, anyOf: []string{"channel:read:subscriptions"}},
+	"channel.subscription.message": {user: conditionBroadcaster, anyOf: []string{"channel:read:subscriptions"}},
+	"channel.cheer":                {user: conditionBroadcaster, anyOf: []string{"bits:read"}},
+	"channel.ban":                  {user: conditionBroadcaster, anyOf: []string{"channel:moderate"}},
+	"channel.unban":                {user: conditionBroadcaster, anyOf: []string{"channel:moderate"}},
+	"channel.moderator.add":        {user: conditionBroadcaster, anyOf: []string{"moderation:read"}},
+	"channel.moderator.remove":     {user: conditionBroadcaster, anyOf: []string{"moderation:read"}},
+	"channel.channel_points_custom_reward_redemption.add":    {user: conditionBroadcaster, anyOf: []string{"channel:read:redemptions", "channel:manage:redemptions"}},
+	"channel.channel_points_custom_reward_redemption.update": {user: conditionBroadcaster, anyOf: []string{"channel:read:redemptions", "channel:manage:redemptions"}},
+	"channel.poll.begin":          {user: conditionBroadcaster, anyOf: []string{"channel:read:polls", "channel:manage:polls"}},
+	"channel.poll.progress":       {user: conditionBroadcaster, anyOf: []string{"channel:read:polls", "channel:manage:polls"}},
+	"channel.poll.end":            {user: conditionBroadcaster, anyOf: []string{"channel:read:polls", "channel:manage:polls"}},
+	"channel.prediction.begin":    {user: conditionBroadcaster, anyOf: []string{"channel:read:predictions", "channel:manage:predictions"}},
+	"channel.prediction.progress": {user: conditionBroadcaster, anyOf: []string{"channel:read:predictions", "channel:manage:predictions"}},
+	"channel.prediction.lock":     {user: conditionBroadcaster, anyOf: []string{"channel:read:predictions", "channel:manage:predictions"}},
+	"channel.prediction.end":      {user: conditionBroadcaster, anyOf: []string{"channel:read:predictions", "channel:manage:predictions"}},
+	"channel.hype_train.begin":    {user: conditionBroadcaster, anyOf: []string{"channel:read:hype_train"}},
+	"channel.hype_train.progress": {user: conditionBroadcaster, anyOf: []string{"channel:read:hype_train"}},
+	"channel.hype_train.end":      {user: conditionBroadcaster, anyOf: []string{"channel:read:hype_train"}},
+	"channel.shoutout.create":     {user: conditionModerator, anyOf: []string{"moderator:read:shoutouts", "moderator:manage:shoutouts"}},
+	"channel.shoutout.receive":    {user: conditionModerator, anyOf: []string{"moderator:read:shoutouts", "moderator:manage:shoutouts"}},
+	// With the app access token, the broadcaster must also have granted channel:bot, unless the user is a moderator
+	// of the channel. Moderator status can not be checked without a request, so that part is left to Helix.
+	"channel.chat.message":        {user: conditionUser, anyOf: []string{"user:read:chat"}, appTokenAllOf: []string{"user:bot"}},
+	"channel.chat.message_delete": {user: conditionUser, anyOf: []string{"user:read:chat"}, appTokenAllOf: []string{"user:bot"}},
+}
+
+// RequiredScopes returns the scopes needed to create a subscription of the given type (eg. "channel.subscribe").
+// The user in the condition must have granted at least one of them to your app.
+// Over the webhook and conduit transports, the chat types (eg. "channel.chat.message") also need "user:bot".
+// Returns nil for types that do not need authorization, or that are not known to this library.
+func RequiredScopes(Type string) []string {
+	return subscriptionScopes[Type].anyOf
+}
+
+// Returns the ID of the user in the condition whose token should be used for a subscription of the given type.
+// Types without a known requirement use the first of the moderator, user, and broadcaster that has a token.
+func (c *Client) authorizingUser(Type string, condition Condition) string {
+	if requirement, ok := subscriptionScopes[Type]; ok {
+		return requirement.user(condition)
+	}
+	c.userTokensMu.Lock()
+	defer c.userTokensMu.Unlock()
+	for _, id := range []string{condition.ModeratorUserID, condition.UserID, condition.BroadcasterUserID} {
+		if _, ok := c.userTokens[id]; id != "" && ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// Returns [MissingScopeError] if the client has a token for the user that must authorize the subscription,
+// and that token lacks the required scopes. appToken is whether the subscription is created with the app access token.
+// Without a token, it is up to Helix to check.
+func (c *Client) checkScopes(Type string, condition Condition, appToken bool) error {
+	requirement, ok := subscriptionScopes[Type]
+	if !ok {
+		return nil
+	}
+	userID := requirement.user(condition)
+	c.userTokensMu.Lock()
+	token, ok := c.userTokens[userID]
+	c.userTokensMu.Unlock()
+	if !ok {
+		return nil
+	}
+	if len(requirement.anyOf) > 0 && !slices.ContainsFunc(requirement.anyOf, token.HasScope) {
+		return &MissingScopeError{Type: Type, UserID: userID, Scopes: requirement.anyOf}
+	}
+	if !appToken {
+		return nil
+	}
+	for _, scope := range requirement.appTokenAllOf {
+		if !token.HasScope(scope) {
+			return &MissingScopeError{Type: Type, UserID: userID, Scopes: []string{scope}}
+		}
+	}
+	return nil
+}
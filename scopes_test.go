@@ -0,0 +1,37 @@
+package twitchwh
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCheckScopes(t *testing.T) {
+	c := newTestClient()
+	c.SetUserToken(UserToken{UserID: "1", AccessToken: "token", Scopes: []string{"user:read:chat"}})
+	condition := Condition{BroadcasterUserID: "2", UserID: "1"}
+
+	// WebSocket subscriptions are created with the user's token, which only needs user:read:chat
+	err := c.checkScopes("channel.chat.message", condition, false)
+	if err != nil {
+		t.Fatalf("Expected user:read:chat to be enough with a user token, got %v", err)
+	}
+
+	var scopeErr *MissingScopeError
+	err = c.checkScopes("channel.chat.message", condition, true)
+	if !errors.As(err, &scopeErr) || scopeErr.UserID != "1" || !slices.Equal(scopeErr.Scopes, []string{"user:bot"}) {
+		t.Fatalf("Expected MissingScopeError for user:bot with the app token, got %v", err)
+	}
+
+	c.SetUserToken(UserToken{UserID: "1", AccessToken: "token", Scopes: []string{"user:bot"}})
+	err = c.checkScopes("channel.chat.message", condition, true)
+	if !errors.As(err, &scopeErr) || !slices.Equal(scopeErr.Scopes, []string{"user:read:chat"}) {
+		t.Fatalf("Expected MissingScopeError for user:read:chat, got %v", err)
+	}
+
+	c.SetUserToken(UserToken{UserID: "1", AccessToken: "token", Scopes: []string{"user:read:chat", "user:bot"}})
+	err = c.checkScopes("channel.chat.message", condition, true)
+	if err != nil {
+		t.Fatalf("Expected user:read:chat and user:bot to be enough with the app token, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package twitchwh
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Keeps track of the webhook secret used for new subscriptions, and the previous ones Client.Handler still accepts.
+type webhookSecrets struct {
+	mu       sync.RWMutex
+	current  string
+	previous []string
+	// IDs of subscriptions created with the current secret by this client
+	created map[string]bool
+}
+
+// Query parameter added to the callback of subscriptions recreated by RotateWebhookSecret. Twitch does not allow two
+// subscriptions with the same callback, so the replacement needs a different one. The value identifies the secret
+// the subscription was created with, without revealing it.
+const secretCallbackParam = "twitchwh_secret"
+
+// RotateWebhookSecret replaces the webhook secret of the client, without failing the verification of any events.
+//
+// New subscriptions are created with the new secret right away, while Handler keeps accepting the previous secrets.
+// Every webhook subscription that was not created with the new secret is then replaced: a copy is created with the new
+// secret, and the old subscription is removed once Twitch has verified the copy, so no events are missed.
+// Once every subscription has been replaced, the previous secrets are retired, and Handler only accepts the new secret.
+//
+// Twitch does not allow two identical subscriptions at once, so the callback of the copy has a twitchwh_secret query
+// parameter that identifies the secret, eg. https://mydomain.com/eventsub?twitchwh_secret=1a2b3c4d. Handler ignores it,
+// and [Client.Reconcile] treats it as the same callback. Since both subscriptions are enabled for a moment, events sent
+// during that time are delivered twice, with different message IDs, so the DedupStore does not catch them.
+//
+// Like [Client.Reconcile], RotateWebhookSecret keeps going when a single subscription fails. It returns the copies
+// created, and the returned error joins every failure. A subscription whose copy could not be created is kept.
+// The previous secrets are kept until every subscription has been replaced, so call it again with the same secret
+// to retry. Subscriptions that have already been replaced are recognized by their callback, so this also works after
+// a restart or on another replica, as long as Handler accepts the old secrets there (see PreviousWebhookSecrets).
+//
+// Conduit shards are not updated. Assign [Client.WebhookShard] to them again after rotating.
+func (c *Client) RotateWebhookSecret(ctx context.Context, secret string) ([]Subscription, error) {
+	c.webhookSecrets.rotate(secret)
+	c.logger.Info("Rotating webhook secret")
+
+	existing, err := c.fetchSubscriptions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var recreated []Subscription
+	var errs []error
+	// Subscriptions that already use the new secret, by spec key
+	replaced := make(map[string]bool)
+	for _, sub := range existing {
+		if sub.Transport.Method != "webhook" || !c.webhookSecrets.isCurrent(sub) {
+			continue
+		}
+		if sub.Status == "enabled" {
+			// Pending copies do not count, since they may still fail verification
+			replaced[specKey(SubscriptionSpec{sub.Type, sub.Version, sub.Condition, sub.Transport.Callback})] = true
+			continue
+		}
+		if !subscriptionActive(sub) && hasSecretID(sub.Transport.Callback) {
+			// A copy that failed in an earlier attempt, which would conflict with a new one
+			err := c.RemoveSubscriptionContext(ctx, sub.ID)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for _, sub := range existing {
+		if sub.Transport.Method != "webhook" || !subscriptionActive(sub) || c.webhookSecrets.isCurrent(sub) {
+			continue
+		}
+		key := specKey(SubscriptionSpec{sub.Type, sub.Version, sub.Condition, sub.Transport.Callback})
+		if !replaced[key] {
+			c.logger.Info("Recreating subscription with new webhook secret", subscriptionAttrs(sub)...)
+			var created Subscription
+			err = c.retryUnauthorized(ctx, func() error {
+				created, err = c.addWebhookSubscription(ctx, withSecretID(sub.Transport.Callback, secret), sub.Type, sub.Version, sub.Condition)
+				return err
+			})
+			if err != nil {
+				// Keep the old subscription, so that no events are missed
+				errs = append(errs, err)
+				continue
+			}
+			replaced[key] = true
+			recreated = append(recreated, created)
+		}
+		err := c.RemoveSubscriptionContext(ctx, sub.ID)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return recreated, errors.Join(errs...)
+	}
+	c.webhookSecrets.retire(secret)
+	c.logger.Info("Retired previous webhook secrets")
+	return recreated, nil
+}
+
+// secretID identifies a secret in the callback of a subscription, see secretCallbackParam.
+func secretID(secret string) string {
+	return generateHmac(secret, secretCallbackParam)[:8]
+}
+
+// withSecretID returns the callback with secretCallbackParam set to identify the secret.
+func withSecretID(callback string, secret string) string {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return callback
+	}
+	query := u.Query()
+	query.Set(secretCallbackParam, secretID(secret))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// hasSecretID returns whether the callback has secretCallbackParam set.
+func hasSecretID(callback string) bool {
+	u, err := url.Parse(callback)
+	return err == nil && u.Query().Has(secretCallbackParam)
+}
+
+// withoutSecretID returns the callback without secretCallbackParam.
+func withoutSecretID(callback string) string {
+	if !strings.Contains(callback, secretCallbackParam) {
+		return callback
+	}
+	u, err := url.Parse(callback)
+	if err != nil {
+		return callback
+	}
+	query := u.Query()
+	query.Del(secretCallbackParam)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// get returns the secret for new subscriptions.
+func (s *webhookSecrets) get() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// verify checks the signature of a webhook message against the current and previous secrets.
+func (s *webhookSecrets) verify(message string, signature string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, secret := range append([]string{s.current}, s.previous...) {
+		if verifyHmac("sha256="+generateHmac(secret, message), signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// rotate makes secret the current secret, and keeps accepting the old one.
+func (s *webhookSecrets) rotate(secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if secret == s.current {
+		// Retrying a rotation
+		return
+	}
+	s.previous = slices.DeleteFunc(s.previous, func(previous string) bool { return previous == secret })
+	s.previous = append(s.previous, s.current)
+	s.current = secret
+	s.created = nil
+}
+
+// retire stops accepting the previous secrets, unless the current secret has been rotated again since.
+func (s *webhookSecrets) retire(secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if secret == s.current {
+		s.previous = nil
+	}
+}
+
+// markCreated records that the subscription was created with secret.
+func (s *webhookSecrets) markCreated(id string, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if secret != s.current {
+		return
+	}
+	if s.created == nil {
+		s.created = make(map[string]bool)
+	}
+	s.created[id] = true
+}
+
+// isCurrent returns whether the subscription is known to have been created with the current secret,
+// either by this client or by RotateWebhookSecret, which marks the callback.
+func (s *webhookSecrets) isCurrent(sub Subscription) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.created[sub.ID] {
+		return true
+	}
+	u, err := url.Parse(sub.Transport.Callback)
+	return err == nil && u.Query().Get(secretCallbackParam) == secretID(s.current)
+}
@@ -2,10 +2,12 @@ package twitchwh
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -61,14 +63,20 @@ type Subscription struct {
 	Transport struct {
 		Method   string `json:"method"`
 		Callback string `json:"callback"`
+		// Only set for WebSocket subscriptions
+		SessionID string `json:"session_id"`
+		// Only set for conduit subscriptions
+		ConduitID string `json:"conduit_id"`
 	} `json:"transport"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 type transport struct {
-	Method   string `json:"method"`
-	Callback string `json:"callback"`
-	Secret   string `json:"secret"`
+	Method    string `json:"method"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	ConduitID string `json:"conduit_id,omitempty"`
 }
 
 type subscriptionRequest struct {
@@ -80,7 +88,7 @@ type subscriptionRequest struct {
 
 // AddSubscription attemps to create a new subscription based on the type, version, and condition.
 // You can find all subscription types, versions, and conditions at: [EventSub subscription types].
-// It will block until Twitch sends the verification request, or timeout after 10 seconds.
+// It will block until Twitch sends the verification request, or timeout after ClientConfig.VerificationTimeout (10 seconds by default).
 //
 // !! AddSubscription should only be called AFTER [twitchwh.Client.Handler] is set up accordingly. !!
 //
@@ -94,132 +102,187 @@ type subscriptionRequest struct {
 //
 // [EventSub subscription types]: https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/
 func (c *Client) AddSubscription(Type string, version string, condition Condition) error {
-	err := c.addSubscription(Type, version, condition)
+	return c.AddSubscriptionContext(context.Background(), Type, version, condition)
+}
+
+// AddSubscriptionContext is like [Client.AddSubscription], but the request and the wait for verification are
+// cancelled when ctx is done. The verification timeout still applies if ctx has no earlier deadline.
+func (c *Client) AddSubscriptionContext(ctx context.Context, Type string, version string, condition Condition) error {
+	_, err := c.CreateSubscription(ctx, Type, version, condition)
+	return err
+}
+
+// CreateSubscription is like [Client.AddSubscriptionContext], but also returns the subscription created by Helix,
+// so that it can be removed by ID later.
+//
+// Once verified, the Status of the returned subscription is "enabled". If the subscription was created but not
+// verified in time, the pending subscription is returned along with a [VerificationTimeoutError].
+//
+//	sub, err := client.CreateSubscription(ctx, "stream.online", "1", twitchwh.Condition{
+//		BroadcasterUserID: "215185844",
+//	})
+//	if err != nil {
+//		log.Panic(err)
+//	}
+//	// Later
+//	client.RemoveSubscriptionContext(ctx, sub.ID)
+func (c *Client) CreateSubscription(ctx context.Context, Type string, version string, condition Condition) (subscription Subscription, err error) {
+	err = c.retryUnauthorized(ctx, func() error {
+		subscription, err = c.addWebhookSubscription(ctx, c.webhookURL, Type, version, condition)
+		return err
+	})
+	return subscription, err
+}
+
+// Internal function that creates a webhook subscription with the provided callback, and waits for verification.
+func (c *Client) addWebhookSubscription(ctx context.Context, callback string, Type string, version string, condition Condition) (Subscription, error) {
+	secret := c.webhookSecrets.get()
+	subscription, err := c.createSubscription(ctx, "", Type, version, condition, transport{
+		Method:   "webhook",
+		Callback: callback,
+		Secret:   secret,
+	})
 	if err != nil {
-		var uaErr *UnauthorizedError
-		if errors.As(err, &uaErr) {
-			c.logger.Println("Token invalid, generating a new one")
-			token, err := c.generateToken(c.clientID, c.clientSecret)
-			if err != nil {
-				return err
-			}
-			c.token = token
-			return c.addSubscription(Type, version, condition)
-		}
+		return Subscription{}, err
+	}
+	c.webhookSecrets.markCreated(subscription.ID, secret)
+
+	// Await confirmation
+	verified := c.awaitVerification(subscription.ID)
+	defer c.forgetVerification(subscription.ID)
+	timeout := time.NewTimer(c.verificationTimeout)
+	defer timeout.Stop()
+	select {
+	case <-verified:
+		c.logger.Info("Subscription created", subscriptionAttrs(subscription)...)
+		subscription.Status = "enabled"
+		return subscription, nil
+	case <-timeout.C:
+		return subscription, &VerificationTimeoutError{subscription}
+	case <-ctx.Done():
+		return subscription, &InternalError{"Stopped waiting for verification", ctx.Err()}
 	}
-	return nil
 }
 
-func (c *Client) addSubscription(Type string, version string, condition Condition) error {
+// Internal function that sends the Create EventSub Subscription request using the provided token and transport.
+// Uses the app access token if token is empty.
+// Returns the subscription created by Helix.
+func (c *Client) createSubscription(ctx context.Context, token string, Type string, version string, condition Condition, transport transport) (Subscription, error) {
+	// Only subscriptions created with the app access token count towards the quota of the client
+	appToken := token == ""
+	if appToken {
+		err := c.quota.check(Type)
+		if err != nil {
+			return Subscription{}, err
+		}
+	}
+	err := c.checkScopes(Type, condition, appToken)
+	if err != nil {
+		return Subscription{}, err
+	}
+
 	reqBody, err := json.Marshal(subscriptionRequest{
 		Type:      Type,
 		Version:   version,
 		Condition: condition,
-		Transport: transport{
-			Method:   "webhook",
-			Callback: c.webhookURL,
-			Secret:   c.webhookSecret,
-		},
+		Transport: transport,
 	})
 	if err != nil {
-		return &InternalError{"Could not serialize request body to JSON", err}
+		return Subscription{}, &InternalError{"Could not serialize request body to JSON", err}
 	}
 
-	request, err := http.NewRequest("POST", helixURL+"/eventsub/subscriptions", bytes.NewBuffer(reqBody))
+	request, err := http.NewRequestWithContext(ctx, "POST", c.helixURL+"/eventsub/subscriptions", bytes.NewBuffer(reqBody))
 	if err != nil {
-		return &InternalError{"Could not create request", err}
+		return Subscription{}, &InternalError{"Could not create request", err}
 	}
 
 	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Client-ID", c.clientID)
-	request.Header.Set("Authorization", "Bearer "+c.token)
+	err = c.authorize(request, token)
+	if err != nil {
+		return Subscription{}, err
+	}
 
-	res, err := c.httpClient.Do(request)
+	res, err := c.do(request, appToken)
 	if err != nil {
-		return &InternalError{"Could not send request", err}
+		return Subscription{}, &InternalError{"Could not send request", err}
 	}
 
 	defer res.Body.Close()
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return &InternalError{"Could not read response body", err}
+		return Subscription{}, &InternalError{"Could not read response body", err}
 	}
 
 	if res.StatusCode == 409 {
-		return &DuplicateSubscriptionError{
+		return Subscription{}, &DuplicateSubscriptionError{
 			Condition: condition,
 			Type:      Type,
 		}
 	}
 
 	if res.StatusCode == 401 {
-		return &UnauthorizedError{}
+		return Subscription{}, &UnauthorizedError{}
+	}
+	if res.StatusCode == 403 {
+		// The user has not authorized the app, or the user access token is missing scopes
+		requirement := subscriptionScopes[Type]
+		userID := ""
+		if requirement.user != nil {
+			userID = requirement.user(condition)
+		}
+		return Subscription{}, &MissingScopeError{
+			Type:          Type,
+			UserID:        userID,
+			Scopes:        requirement.anyOf,
+			OriginalError: &UnhandledStatusError{res.StatusCode, body},
+		}
 	}
 	if res.StatusCode != 202 {
-		return &UnhandledStatusError{res.StatusCode, body}
+		return Subscription{}, &UnhandledStatusError{res.StatusCode, body}
 	}
 
 	var responseBody struct {
+		SubscriptionQuota
 		Data []Subscription `json:"data"`
 	}
 
 	err = json.Unmarshal(body, &responseBody)
 	if err != nil {
-		return &InternalError{"Could not parse response body", err}
+		return Subscription{}, &InternalError{"Could not parse response body", err}
 	}
 
 	// Returned body is an array that contains a single subscription
 	if len(responseBody.Data) < 1 {
-		return &InternalError{"Helix did not return the subscription they were supposed to", nil}
+		return Subscription{}, &InternalError{"Helix did not return the subscription they were supposed to", nil}
 	}
-	subscription := responseBody.Data[0]
-
-	// Await confirmation
-	for {
-		select {
-		case id := <-c.verifiedSubscriptions:
-			if id == subscription.ID {
-				c.logger.Printf("Subscription created: %s", subscription.ID)
-				return nil
-			} else {
-				// Verified subscription was not for this subscription
-				c.logger.Println("Subscription confirmation did not match ID, ignoring...")
-				c.verifiedSubscriptions <- id
-				continue
-			}
-		case <-time.After(10 * time.Second):
-			return &VerificationTimeoutError{subscription}
-		}
+	if appToken {
+		c.quota.update(responseBody.SubscriptionQuota, responseBody.Data[0])
 	}
+	return responseBody.Data[0], nil
 }
 
 // RemoveSubscription attempts to remove a subscription based on the ID.
 // Returns [SubscriptionNotFoundError] if the subscription does not exist.
 func (c *Client) RemoveSubscription(id string) error {
-	err := c.removeSubscription(id)
-	if err != nil {
-		var uaErr *UnauthorizedError
-		if errors.As(err, &uaErr) {
-			c.logger.Println("Token invalid, generating a new one")
-			token, err := c.generateToken(c.clientID, c.clientSecret)
-			if err != nil {
-				return err
-			}
-			c.token = token
-			return c.removeSubscription(id)
-		}
-	}
-	return err
+	return c.RemoveSubscriptionContext(context.Background(), id)
 }
 
-func (c *Client) removeSubscription(id string) error {
+// RemoveSubscriptionContext is like [Client.RemoveSubscription], but the request is cancelled when ctx is done.
+func (c *Client) RemoveSubscriptionContext(ctx context.Context, id string) error {
+	return c.retryUnauthorized(ctx, func() error {
+		return c.removeSubscription(ctx, id)
+	})
+}
+
+func (c *Client) removeSubscription(ctx context.Context, id string) error {
 	url := "/eventsub/subscriptions?id=" + id
-	res, err := c.genericRequest("DELETE", url)
+	res, err := c.genericRequest(ctx, "DELETE", url)
 	if err != nil {
 		return &InternalError{"Could not make request", err}
 	}
 
 	if res.StatusCode == 204 {
+		c.quota.removed(id)
 		return nil
 	}
 	if res.StatusCode == 401 {
@@ -246,15 +309,20 @@ func (c *Client) removeSubscription(id string) error {
 //
 // Note: This will remove ALL subscriptions that match the provided type and condition.
 func (c *Client) RemoveSubscriptionByType(Type string, condition Condition) error {
-	subs, err := c.GetSubscriptionsByType(Type)
+	return c.RemoveSubscriptionByTypeContext(context.Background(), Type, condition)
+}
+
+// RemoveSubscriptionByTypeContext is like [Client.RemoveSubscriptionByType], but the requests are cancelled when ctx is done.
+func (c *Client) RemoveSubscriptionByTypeContext(ctx context.Context, Type string, condition Condition) error {
+	subs, err := c.GetSubscriptionsByTypeContext(ctx, Type)
 	if err != nil {
 		return err
 	}
 	for _, sub := range subs {
 		// Both of these conditions have unused fields, but since they are both defaulted and of the same type it should be fine
 		if sub.Condition == condition {
-			c.logger.Printf("Removing subscription %s", sub.ID)
-			err := c.RemoveSubscription(sub.ID)
+			c.logger.Debug("Removing subscription", subscriptionAttrs(sub)...)
+			err := c.RemoveSubscriptionContext(ctx, sub.ID)
 			if err != nil {
 				return err
 			}
@@ -266,33 +334,33 @@ func (c *Client) RemoveSubscriptionByType(Type string, condition Condition) erro
 // Internal function to fetch subscriptions using the provided URL parameters.
 // Used by wrapper functions.
 // Automatically handles pagination.
-func (c *Client) fetchSubscriptions(urlParams string) (subscriptions []Subscription, err error) {
+func (c *Client) fetchSubscriptions(ctx context.Context, urlParams string) (subscriptions []Subscription, err error) {
 	page := 1
 	cursor := ""
 	for {
-		c.logger.Printf("Fetching page %d of subscriptions", page)
+		c.logger.Debug("Fetching subscriptions", "page", page)
 		page++
 
-		var params string
+		// The filter has to be sent with every page, not just the first one
+		params := urlParams
 		if cursor != "" {
-			if urlParams == "" {
-				params = urlParams + "?after=" + cursor
+			if params == "" {
+				params = "?after=" + url.QueryEscape(cursor)
 			} else {
-				params = "&after=" + cursor
+				params += "&after=" + url.QueryEscape(cursor)
 			}
 		}
-		res, err := c.genericRequest("GET", "/eventsub/subscriptions"+params)
+		res, err := c.genericRequest(ctx, "GET", "/eventsub/subscriptions"+params)
 		if err != nil {
 			return nil, &InternalError{"Could not make request", err}
 		}
 		if res.StatusCode == 401 {
-			c.logger.Println("Token invalid, generating a new one")
-			token, err := c.generateToken(c.clientID, c.clientSecret)
+			res.Body.Close()
+			err := c.refreshToken(ctx, strings.TrimPrefix(res.Request.Header.Get("Authorization"), "Bearer "))
 			if err != nil {
 				return nil, err
 			}
-			c.token = token
-			res, err = c.genericRequest("GET", "/eventsub/subscriptions"+params)
+			res, err = c.genericRequest(ctx, "GET", "/eventsub/subscriptions"+params)
 			if err != nil {
 				return nil, &InternalError{"Could not make request", err}
 			}
@@ -309,6 +377,7 @@ func (c *Client) fetchSubscriptions(urlParams string) (subscriptions []Subscript
 		}
 
 		var responseStruct struct {
+			SubscriptionQuota
 			Data       []Subscription `json:"data"`
 			Pagination struct {
 				Cursor string `json:"cursor"`
@@ -320,6 +389,7 @@ func (c *Client) fetchSubscriptions(urlParams string) (subscriptions []Subscript
 		}
 
 		subscriptions = append(subscriptions, responseStruct.Data...)
+		c.quota.update(responseStruct.SubscriptionQuota, responseStruct.Data...)
 
 		if responseStruct.Pagination.Cursor == "" {
 			// No more subscriptions to fetch
@@ -335,8 +405,13 @@ func (c *Client) fetchSubscriptions(urlParams string) (subscriptions []Subscript
 //
 // Returns subscriptions and an error (if any).
 func (c *Client) GetSubscriptions() (subscriptions []Subscription, err error) {
+	return c.GetSubscriptionsContext(context.Background())
+}
+
+// GetSubscriptionsContext is like [Client.GetSubscriptions], but the requests are cancelled when ctx is done.
+func (c *Client) GetSubscriptionsContext(ctx context.Context) (subscriptions []Subscription, err error) {
 	urlParams := ""
-	return c.fetchSubscriptions(urlParams)
+	return c.fetchSubscriptions(ctx, urlParams)
 }
 
 // Get all subscriptions that match the provided type (eg. "stream.online").
@@ -344,8 +419,13 @@ func (c *Client) GetSubscriptions() (subscriptions []Subscription, err error) {
 //
 // Returns subscriptions and an error (if any).
 func (c *Client) GetSubscriptionsByType(Type string) (subscriptions []Subscription, err error) {
-	urlParams := "?type=" + Type
-	return c.fetchSubscriptions(urlParams)
+	return c.GetSubscriptionsByTypeContext(context.Background(), Type)
+}
+
+// GetSubscriptionsByTypeContext is like [Client.GetSubscriptionsByType], but the requests are cancelled when ctx is done.
+func (c *Client) GetSubscriptionsByTypeContext(ctx context.Context, Type string) (subscriptions []Subscription, err error) {
+	urlParams := "?type=" + url.QueryEscape(Type)
+	return c.fetchSubscriptions(ctx, urlParams)
 }
 
 // Get all subscriptions with the provided status.
@@ -354,6 +434,11 @@ func (c *Client) GetSubscriptionsByType(Type string) (subscriptions []Subscripti
 //
 // Returns subscriptions and an error (if any).
 func (c *Client) GetSubscriptionsByStatus(status string) (subscriptions []Subscription, err error) {
-	urlParams := "?status=" + status
-	return c.fetchSubscriptions(urlParams)
+	return c.GetSubscriptionsByStatusContext(context.Background(), status)
+}
+
+// GetSubscriptionsByStatusContext is like [Client.GetSubscriptionsByStatus], but the requests are cancelled when ctx is done.
+func (c *Client) GetSubscriptionsByStatusContext(ctx context.Context, status string) (subscriptions []Subscription, err error) {
+	urlParams := "?status=" + url.QueryEscape(status)
+	return c.fetchSubscriptions(ctx, urlParams)
 }
@@ -0,0 +1,159 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newMockServer serves the token endpoint and a paginated Get EventSub Subscriptions endpoint.
+// Every page must be requested with the filter query (eg. "type=stream.online").
+func newMockServer(t *testing.T, filter string, pages [][]Subscription) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "id" || r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte(`{"access_token":"token","expires_in":3600,"token_type":"bearer"}`))
+	})
+	mux.HandleFunc("GET /helix/eventsub/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Client-ID") != "id" {
+			w.WriteHeader(401)
+			return
+		}
+		query := r.URL.Query()
+		after := query.Get("after")
+		query.Del("after")
+		if query.Encode() != filter {
+			t.Errorf("Expected filter %q on every page, got %q", filter, r.URL.RawQuery)
+		}
+		page := 0
+		if after != "" {
+			var err error
+			// Cursors contain characters that have to be escaped
+			page, err = strconv.Atoi(strings.TrimPrefix(after, "page+"))
+			if err != nil {
+				t.Errorf("Invalid cursor %q", after)
+				w.WriteHeader(400)
+				return
+			}
+		}
+		var response struct {
+			Data       []Subscription    `json:"data"`
+			Pagination map[string]string `json:"pagination"`
+		}
+		response.Data = pages[page]
+		response.Pagination = map[string]string{}
+		if page+1 < len(pages) {
+			response.Pagination["cursor"] = "page+" + strconv.Itoa(page+1)
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetSubscriptionsPagination(t *testing.T) {
+	server := newMockServer(t, "type=stream.online", [][]Subscription{
+		{{ID: "1", Type: "stream.online"}, {ID: "2", Type: "stream.online"}},
+		{{ID: "3", Type: "stream.online"}},
+	})
+
+	c, err := New(ClientConfig{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		HelixURL:     server.URL + "/helix",
+		OAuthURL:     server.URL + "/oauth2/",
+		HTTPClient:   server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err := c.GetSubscriptionsByType("stream.online")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 3 || subs[2].ID != "3" {
+		t.Fatalf("Expected 3 subscriptions from 2 pages, got %v", subs)
+	}
+}
+
+func TestGetSubscriptionsByStatusPagination(t *testing.T) {
+	server := newMockServer(t, "status=enabled", [][]Subscription{
+		{{ID: "1", Status: "enabled"}},
+		{{ID: "2", Status: "enabled"}},
+		{{ID: "3", Status: "enabled"}},
+	})
+
+	c, err := New(ClientConfig{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		HelixURL:     server.URL + "/helix",
+		OAuthURL:     server.URL + "/oauth2/",
+		HTTPClient:   server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err := c.GetSubscriptionsByStatus("enabled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 3 || subs[2].ID != "3" {
+		t.Fatalf("Expected 3 subscriptions from 3 pages, got %v", subs)
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+	server := newMockServer(t, "", [][]Subscription{{}})
+	c, err := New(ClientConfig{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		HelixURL:     server.URL + "/helix",
+		OAuthURL:     server.URL + "/oauth2/",
+		HTTPClient:   server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.GetSubscriptionsContext(ctx)
+	var internalErr *InternalError
+	if !errors.As(err, &internalErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected InternalError wrapping context.Canceled, got %v", err)
+	}
+}
+
+func TestCreateSubscriptionForbidden(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(403)
+		w.Write([]byte(`{"error":"Forbidden","status":403,"message":"subscription missing proper authorization"}`))
+	}))
+	defer server.Close()
+	c := newTestClient()
+	c.helixURL = server.URL
+
+	_, err := c.CreateSubscription(context.Background(), "channel.subscribe", "1", Condition{BroadcasterUserID: "1"})
+	var scopeErr *MissingScopeError
+	if !errors.As(err, &scopeErr) || scopeErr.UserID != "1" {
+		t.Fatalf("Expected MissingScopeError, got %v", err)
+	}
+	var statusErr *UnhandledStatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != 403 || !strings.Contains(string(statusErr.Body), "proper authorization") {
+		t.Fatalf("Expected the Helix response to be wrapped, got %v", scopeErr.OriginalError)
+	}
+	if !strings.Contains(err.Error(), "subscription missing proper authorization") {
+		t.Fatalf("Expected the Helix message in the error, got %q", err)
+	}
+}
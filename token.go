@@ -1,25 +1,116 @@
 package twitchwh
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 const oauthURL = "https://id.twitch.tv/oauth2"
-const tokenURL = oauthURL + "/token"
-const validateURL = oauthURL + "/validate"
 
-func (c *Client) generateToken(clientID string, secret string) (token string, err error) {
+// TokenProvider supplies the app access token used for Helix requests.
+// Implementations must be safe for concurrent use.
+//
+// The default is [ClientCredentials]. Use [StaticToken] for a token managed elsewhere, or implement
+// TokenProvider to get tokens from a secrets service or a cache shared between processes.
+type TokenProvider interface {
+	// Token returns the current token, generating one if needed.
+	Token(ctx context.Context) (string, error)
+	// Refresh returns a new token after rejected was rejected by Twitch, either by Helix or by the hourly validation.
+	Refresh(ctx context.Context, rejected string) (string, error)
+}
+
+// ClientCredentials is a [TokenProvider] that generates app access tokens with the client credentials grant flow.
+// It is used by default, with the ClientID, ClientSecret, OAuthURL and HTTPClient of the ClientConfig.
+type ClientCredentials struct {
+	ClientID     string
+	ClientSecret string
+	// Base URL of the Twitch OAuth API. Defaults to https://id.twitch.tv/oauth2
+	OAuthURL string
+	// Defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	token string
+	// Set by New to trace token requests with ClientConfig.TracerProvider
+	tracer trace.Tracer
+}
+
+// Token returns the current token, generating one on the first call.
+func (p *ClientCredentials) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == "" {
+		return p.generate(ctx)
+	}
+	return p.token, nil
+}
+
+// Refresh generates a new token, unless the token was already refreshed since rejected was returned.
+func (p *ClientCredentials) Refresh(ctx context.Context, rejected string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && p.token != rejected {
+		return p.token, nil
+	}
+	return p.generate(ctx)
+}
+
+// Must be called with mu held.
+func (p *ClientCredentials) generate(ctx context.Context) (string, error) {
+	baseURL := p.OAuthURL
+	if baseURL == "" {
+		baseURL = oauthURL
+	}
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	tracer := p.tracer
+	if tracer == nil {
+		tracer = newTracer(nil)
+	}
+	token, err := generateToken(ctx, httpClient, tracer, strings.TrimSuffix(baseURL, "/"), p.ClientID, p.ClientSecret)
+	if err != nil {
+		return "", err
+	}
+	p.token = token
+	return token, nil
+}
+
+// StaticToken is a [TokenProvider] that always returns the same token. Useful for tests, or when the token is refreshed elsewhere.
+// Refresh returns [UnauthorizedError], since a static token can not be replaced.
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t StaticToken) Refresh(ctx context.Context, rejected string) (string, error) {
+	return "", &UnauthorizedError{}
+}
+
+func generateToken(ctx context.Context, httpClient *http.Client, tracer trace.Tracer, oauthURL string, clientID string, secret string) (token string, err error) {
 	values := url.Values{
 		"client_id":     {clientID},
 		"client_secret": {secret},
 		"grant_type":    {"client_credentials"},
 	}
 
-	res, err := c.httpClient.PostForm(tokenURL, values)
+	req, err := http.NewRequestWithContext(ctx, "POST", oauthURL+"/token", strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", &InternalError{"Could not create request", err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := traceRequest(tracer, req, httpClient.Do)
 	if err != nil {
 		return "", &InternalError{"Could not send request", err}
 	}
@@ -52,18 +143,44 @@ func (c *Client) generateToken(clientID string, secret string) (token string, er
 	return jsonBody.AccessToken, nil
 }
 
-func (c *Client) validateToken(token string) (bool, error) {
-	req, err := http.NewRequest("GET", validateURL, nil)
+// revokeToken revokes the app access token. See: https://dev.twitch.tv/docs/authentication/revoke-tokens/
+func (c *Client) revokeToken(ctx context.Context, token string) error {
+	values := url.Values{
+		"client_id": {c.clientID},
+		"token":     {token},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.oauthURL+"/revoke", strings.NewReader(values.Encode()))
+	if err != nil {
+		return &InternalError{"Could not create request", err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := traceRequest(c.tracer, req, c.httpClient.Do)
+	if err != nil {
+		return &InternalError{"Could not send request", err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		return &UnhandledStatusError{res.StatusCode, body}
+	}
+	c.logger.Info("Revoked app access token")
+	return nil
+}
+
+func (c *Client) validateToken(ctx context.Context, token string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.oauthURL+"/validate", nil)
 	if err != nil {
 		return false, &InternalError{"Could not create request", err}
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-	res, err := c.httpClient.Do(req)
+	res, err := traceRequest(c.tracer, req, c.httpClient.Do)
 	if err != nil {
 		return false, &InternalError{"Could not send request", err}
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode == 200 {
 		return true, nil
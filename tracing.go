@@ -0,0 +1,105 @@
+package twitchwh
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer used for every span of the client
+const tracerName = "github.com/LinneB/twitchwh"
+
+// Span attributes
+const (
+	attrMessageID           = attribute.Key("twitch.eventsub.message_id")
+	attrMessageType         = attribute.Key("twitch.eventsub.message_type")
+	attrMessageRetry        = attribute.Key("twitch.eventsub.message_retry")
+	attrSubscriptionID      = attribute.Key("twitch.eventsub.subscription_id")
+	attrSubscriptionType    = attribute.Key("twitch.eventsub.subscription_type")
+	attrSubscriptionVersion = attribute.Key("twitch.eventsub.subscription_version")
+	attrBroadcasterUserID   = attribute.Key("twitch.broadcaster_user_id")
+	attrSessionID           = attribute.Key("twitch.eventsub.session_id")
+	attrHTTPMethod          = attribute.Key("http.request.method")
+	attrHTTPStatus          = attribute.Key("http.response.status_code")
+	attrURLPath             = attribute.Key("url.path")
+	attrServerAddress       = attribute.Key("server.address")
+	attrRetryCount          = attribute.Key("http.request.resend_count")
+)
+
+// Returns the tracer for the provider, or for the global provider if nil
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// Returns span attributes from the headers of a webhook request, which are known before the body is verified
+func webhookAttrs(r *http.Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attrMessageID.String(r.Header.Get(twitchMessageID)),
+		attrMessageType.String(r.Header.Get(messageType)),
+		attrSubscriptionType.String(r.Header.Get(twitchSubscriptionType)),
+		attrSubscriptionVersion.String(r.Header.Get(twitchSubscriptionVersion)),
+	}
+	if retry, err := strconv.Atoi(r.Header.Get(twitchMessageRetry)); err == nil {
+		attrs = append(attrs, attrMessageRetry.Int(retry))
+	}
+	return attrs
+}
+
+// Returns span attributes identifying a subscription
+func subscriptionSpanAttrs(subscription Subscription) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attrSubscriptionID.String(subscription.ID),
+		attrSubscriptionType.String(subscription.Type),
+		attrSubscriptionVersion.String(subscription.Version),
+	}
+	if subscription.Condition.BroadcasterUserID != "" {
+		attrs = append(attrs, attrBroadcasterUserID.String(subscription.Condition.BroadcasterUserID))
+	}
+	return attrs
+}
+
+// Sends req in a client span that is a child of the span in the context of req.
+func traceRequest(tracer trace.Tracer, req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	ctx, span := startRequestSpan(tracer, req)
+	defer span.End()
+	res, err := do(req.WithContext(ctx))
+	endRequestSpan(span, res, err)
+	return res, err
+}
+
+func startRequestSpan(tracer trace.Tracer, req *http.Request) (context.Context, trace.Span) {
+	return tracer.Start(req.Context(), req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrHTTPMethod.String(req.Method),
+			attrURLPath.String(req.URL.Path),
+			attrServerAddress.String(req.URL.Hostname()),
+		),
+	)
+}
+
+// Records the result of a request on its span, without ending it.
+func endRequestSpan(span trace.Span, res *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attrHTTPStatus.Int(res.StatusCode))
+	if res.StatusCode >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+}
+
+// Marks the span as failed.
+func spanError(span trace.Span, description string) {
+	span.SetStatus(codes.Error, description)
+}
@@ -0,0 +1,95 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracingTestClient() (*Client, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	c := newTestClient()
+	c.tracer = newTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return c, recorder
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestHandlerTracing(t *testing.T) {
+	c, recorder := newTracingTestClient()
+	handled := make(chan trace.SpanContext, 1)
+	c.OnContext("stream.online", func(ctx context.Context, event json.RawMessage) error {
+		handled <- trace.SpanContextFromContext(ctx)
+		return nil
+	})
+
+	r := newSignedRequest("1", messageTypeNotification, time.Now(), testNotification)
+	r.Header.Set(twitchMessageRetry, "2")
+	r.Header.Set(twitchSubscriptionType, "stream.online")
+	r.Header.Set(twitchSubscriptionVersion, "1")
+	c.Handler(httptest.NewRecorder(), r)
+	handlerSpan := <-handled
+
+	// The handler span ends after the handler returns
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.Ended()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 spans, got %d", len(recorder.Ended()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	webhook, handler := recorder.Ended()[0], recorder.Ended()[1]
+	if webhook.Name() != "eventsub webhook" {
+		// The handler may finish before the webhook request
+		webhook, handler = handler, webhook
+	}
+	if webhook.Name() != "eventsub webhook" || webhook.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("Unexpected webhook span %s", webhook.Name())
+	}
+	if spanAttr(webhook, attrMessageID).AsString() != "1" || spanAttr(webhook, attrMessageRetry).AsInt64() != 2 ||
+		spanAttr(webhook, attrSubscriptionType).AsString() != "stream.online" || spanAttr(webhook, attrSubscriptionVersion).AsString() != "1" {
+		t.Fatalf("Unexpected webhook span attributes %v", webhook.Attributes())
+	}
+	if handler.SpanContext().SpanID() != handlerSpan.SpanID() || handler.Parent().SpanID() != webhook.SpanContext().SpanID() {
+		t.Fatal("Handler context does not carry a child span of the webhook span")
+	}
+}
+
+func TestHelixTracing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	c, recorder := newTracingTestClient()
+	c.helixURL = server.URL
+
+	ctx, parent := c.tracer.Start(context.Background(), "parent")
+	err := c.jsonRequest(ctx, "GET", "/eventsub/subscriptions", nil, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	helix := recorder.Ended()[0]
+	if helix.Name() != "GET /eventsub/subscriptions" || helix.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("Expected Helix span to be a child of the parent span, got %s", helix.Name())
+	}
+	if spanAttr(helix, attrHTTPStatus).AsInt64() != 200 {
+		t.Fatalf("Unexpected Helix span attributes %v", helix.Attributes())
+	}
+}
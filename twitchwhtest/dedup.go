@@ -0,0 +1,53 @@
+// Package twitchwhtest provides fakes for testing code that uses twitchwh.
+package twitchwhtest
+
+import (
+	"context"
+	"sync"
+)
+
+// DedupStore is an in-process fake of twitchwh.DedupStore.
+// It remembers every message ID forever, and records every call for inspection.
+//
+// Share a single DedupStore between several clients to simulate replicas using a shared store.
+type DedupStore struct {
+	// If set, MarkHandled returns this error instead of marking the message ID
+	Err error
+
+	mu      sync.Mutex
+	handled map[string]bool
+	calls   []string
+}
+
+// NewDedupStore creates a new, empty DedupStore.
+func NewDedupStore() *DedupStore {
+	return &DedupStore{handled: make(map[string]bool)}
+}
+
+func (s *DedupStore) MarkHandled(ctx context.Context, messageID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, messageID)
+	if s.Err != nil {
+		return false, s.Err
+	}
+	if s.handled[messageID] {
+		return false, nil
+	}
+	s.handled[messageID] = true
+	return true, nil
+}
+
+// Handled reports whether the message ID has been marked as handled.
+func (s *DedupStore) Handled(messageID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handled[messageID]
+}
+
+// Calls returns the message IDs passed to MarkHandled, in order.
+func (s *DedupStore) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
@@ -0,0 +1,850 @@
+package twitchwhtest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LinneB/twitchwh"
+)
+
+// Server is a fake Twitch API serving the OAuth endpoints, the EventSub subscription endpoints, and the conduit endpoints.
+//
+// When a webhook subscription is created, the server performs the webhook_callback_verification challenge
+// against the callback, just like Twitch does. Once verified, signed notifications and revocations can be
+// pushed with Notify, Revoke, and Send.
+//
+// Conduit subscriptions and conduit shards are enabled right away, without a challenge. Events are not
+// delivered to conduit shards.
+//
+//	server := twitchwhtest.NewServer()
+//	defer server.Close()
+//
+//	client, _ := twitchwh.New(twitchwh.ClientConfig{
+//		ClientID:      "id",
+//		ClientSecret:  "secret",
+//		WebhookSecret: "supersecretstring",
+//		WebhookURL:    "https://example.com/eventsub",
+//		HelixURL:      server.HelixURL(),
+//		OAuthURL:      server.OAuthURL(),
+//	})
+//	// Deliver webhook requests in-process instead of to WebhookURL
+//	server.Webhook = http.HandlerFunc(client.Handler)
+//
+//	client.AddSubscription("stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+//	server.Notify(subscriptionID, twitchwh.StreamOnlineEvent{BroadcasterUserID: "1"})
+type Server struct {
+	// URL of the server, without any path
+	URL string
+
+	// If set, webhook requests are served by this handler instead of being sent to the callback URL of the subscription.
+	Webhook http.Handler
+	// Maximum number of subscriptions and conduit shards returned per page. Defaults to 100
+	PageSize int
+	// Value returned as max_total_cost. Defaults to 10000
+	MaxTotalCost int
+	// ID of the user that approves requests to the authorize endpoint, granting the requested scopes.
+	// If empty, the authorize endpoint redirects back with error=access_denied.
+	AuthorizeUserID string
+
+	server *httptest.Server
+
+	mu    sync.Mutex
+	token string
+	users map[string]*user
+	// Authorization codes issued by the authorize endpoint
+	codes         map[string]authorizationCode
+	subscriptions map[string]*subscription
+	// Subscription IDs in order of creation
+	order    []string
+	conduits map[string]*conduit
+	// Conduit IDs in order of creation
+	conduitOrder []string
+}
+
+// Lifetime of user access tokens issued by the server
+const userTokenLifetime = 4 * time.Hour
+
+// A user that has authorized the app
+type user struct {
+	id           string
+	scopes       []string
+	accessToken  string
+	refreshToken string
+}
+
+type authorizationCode struct {
+	userID      string
+	redirectURI string
+}
+
+type subscription struct {
+	twitchwh.Subscription
+	secret string
+}
+
+type conduit struct {
+	twitchwh.Conduit
+	// Shards without a transport have an empty status
+	shards []twitchwh.Shard
+}
+
+// Maximum number of shards of a conduit
+const maxShardCount = 20000
+
+// Adds or removes shards until the conduit has count shards.
+func (c *conduit) resize(count int) {
+	for len(c.shards) < count {
+		c.shards = append(c.shards, twitchwh.Shard{ID: strconv.Itoa(len(c.shards))})
+	}
+	c.shards = c.shards[:count]
+	c.ShardCount = count
+}
+
+// NewServer starts a new Server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		PageSize:      100,
+		MaxTotalCost:  10000,
+		users:         make(map[string]*user),
+		codes:         make(map[string]authorizationCode),
+		subscriptions: make(map[string]*subscription),
+		conduits:      make(map[string]*conduit),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth2/token", s.handleToken)
+	mux.HandleFunc("GET /oauth2/validate", s.handleValidate)
+	mux.HandleFunc("POST /oauth2/revoke", s.handleRevoke)
+	mux.HandleFunc("GET /oauth2/authorize", s.handleAuthorize)
+	mux.HandleFunc("POST /helix/eventsub/subscriptions", s.authorized(s.handleCreate))
+	mux.HandleFunc("GET /helix/eventsub/subscriptions", s.authorized(s.handleGet))
+	mux.HandleFunc("DELETE /helix/eventsub/subscriptions", s.authorized(s.handleDelete))
+	mux.HandleFunc("POST /helix/eventsub/conduits", s.authorized(s.handleCreateConduit))
+	mux.HandleFunc("GET /helix/eventsub/conduits", s.authorized(s.handleGetConduits))
+	mux.HandleFunc("PATCH /helix/eventsub/conduits", s.authorized(s.handleUpdateConduit))
+	mux.HandleFunc("DELETE /helix/eventsub/conduits", s.authorized(s.handleDeleteConduit))
+	mux.HandleFunc("GET /helix/eventsub/conduits/shards", s.authorized(s.handleGetShards))
+	mux.HandleFunc("PATCH /helix/eventsub/conduits/shards", s.authorized(s.handleUpdateShards))
+
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// HelixURL returns the value for twitchwh.ClientConfig.HelixURL
+func (s *Server) HelixURL() string {
+	return s.URL + "/helix"
+}
+
+// OAuthURL returns the value for twitchwh.ClientConfig.OAuthURL
+func (s *Server) OAuthURL() string {
+	return s.URL + "/oauth2"
+}
+
+// ExpireToken invalidates the current app access token, so that the next Helix request returns 401 Unauthorized.
+func (s *Server) ExpireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// AddUser authorizes the app for the user with the scopes, and returns a user access token for the user.
+// The token can be refreshed with the refresh_token grant, which rotates both the access and refresh token.
+func (s *Server) AddUser(userID string, scopes ...string) twitchwh.UserToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := &user{id: userID, scopes: scopes}
+	s.users[userID] = u
+	return s.issueUserToken(u)
+}
+
+// ExpireUserToken invalidates the current user access token of the user. The refresh token stays valid.
+func (s *Server) ExpireUserToken(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok {
+		u.accessToken = ""
+	}
+}
+
+// Generates new tokens for the user. Must be called with mu held.
+func (s *Server) issueUserToken(u *user) twitchwh.UserToken {
+	u.accessToken = randomID()
+	u.refreshToken = randomID()
+	return twitchwh.UserToken{
+		UserID:       u.id,
+		AccessToken:  u.accessToken,
+		RefreshToken: u.refreshToken,
+		Scopes:       u.scopes,
+		Expiry:       time.Now().Add(userTokenLifetime),
+	}
+}
+
+// Subscriptions returns all subscriptions in order of creation, including revoked ones.
+func (s *Server) Subscriptions() []twitchwh.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriptions := make([]twitchwh.Subscription, 0, len(s.order))
+	for _, id := range s.order {
+		subscriptions = append(subscriptions, s.subscriptions[id].Subscription)
+	}
+	return subscriptions
+}
+
+// Shards returns all shards of the conduit, or nil if the conduit does not exist.
+func (s *Server) Shards(conduitID string) []twitchwh.Shard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.conduits[conduitID]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(c.shards)
+}
+
+// Message is a single webhook request sent by Send.
+type Message struct {
+	// Twitch-Eventsub-Message-Id. Defaults to a random ID
+	ID string
+	// Twitch-Eventsub-Message-Type, eg. notification or revocation. Defaults to notification
+	Type string
+	// Twitch-Eventsub-Message-Timestamp. Defaults to the current time
+	Timestamp time.Time
+	// Twitch-Eventsub-Message-Retry
+	Retry int
+	// Subscription the message is for
+	SubscriptionID string
+	// Event body, serialized to JSON. Only used for notifications
+	Event any
+}
+
+// Notify sends a signed notification with the event for the subscription.
+// Returns the status code returned by the webhook.
+func (s *Server) Notify(subscriptionID string, event any) (int, error) {
+	return s.Send(Message{SubscriptionID: subscriptionID, Event: event})
+}
+
+// Revoke marks the subscription as revoked with the status (eg. "user_removed") and sends a revocation message.
+// Returns the status code returned by the webhook.
+func (s *Server) Revoke(subscriptionID string, status string) (int, error) {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[subscriptionID]
+	if ok {
+		sub.Status = status
+	}
+	s.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("unknown subscription %s", subscriptionID)
+	}
+	return s.Send(Message{Type: "revocation", SubscriptionID: subscriptionID})
+}
+
+// Send sends a signed webhook request for the subscription.
+// Returns the status code returned by the webhook.
+func (s *Server) Send(message Message) (int, error) {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[message.SubscriptionID]
+	var copied subscription
+	if ok {
+		copied = *sub
+	}
+	s.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("unknown subscription %s", message.SubscriptionID)
+	}
+	if copied.Transport.Method != "webhook" {
+		return 0, fmt.Errorf("subscription %s does not use the webhook transport", message.SubscriptionID)
+	}
+	if message.Type == "" {
+		message.Type = "notification"
+	}
+
+	payload := map[string]any{"subscription": copied.Subscription}
+	if message.Type == "notification" {
+		payload["event"] = message.Event
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.deliver(copied, message, body)
+	if err != nil {
+		return 0, err
+	}
+	return res.StatusCode, nil
+}
+
+// Sends a signed request to the webhook of the subscription.
+func (s *Server) deliver(sub subscription, message Message, body []byte) (*http.Response, error) {
+	if message.ID == "" {
+		message.ID = randomID()
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	timestamp := message.Timestamp.UTC().Format(time.RFC3339Nano)
+
+	req, err := http.NewRequest("POST", sub.Transport.Callback, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Twitch-Eventsub-Message-Id", message.ID)
+	req.Header.Set("Twitch-Eventsub-Message-Retry", strconv.Itoa(message.Retry))
+	req.Header.Set("Twitch-Eventsub-Message-Type", message.Type)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", "sha256="+sign(sub.secret, message.ID+timestamp+string(body)))
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Subscription-Type", sub.Type)
+	req.Header.Set("Twitch-Eventsub-Subscription-Version", sub.Version)
+
+	if s.Webhook != nil {
+		w := httptest.NewRecorder()
+		s.Webhook.ServeHTTP(w, req)
+		return w.Result(), nil
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// Performs the challenge request for a new webhook subscription, and updates its status with the result.
+func (s *Server) verify(sub subscription) {
+	challenge := randomID()
+	body, _ := json.Marshal(map[string]any{
+		"challenge":    challenge,
+		"subscription": sub.Subscription,
+	})
+
+	status := "webhook_callback_verification_failed"
+	res, err := s.deliver(sub, Message{Type: "webhook_callback_verification"}, body)
+	if err == nil {
+		response, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode == 200 && string(response) == challenge {
+			status = "enabled"
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.subscriptions[sub.ID]; ok {
+		stored.Status = status
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") == "" || r.FormValue("client_secret") == "" {
+		w.WriteHeader(400)
+		return
+	}
+	switch r.FormValue("grant_type") {
+	case "client_credentials":
+		s.mu.Lock()
+		if s.token == "" {
+			s.token = randomID()
+		}
+		token := s.token
+		s.mu.Unlock()
+		writeJSON(w, 200, map[string]any{
+			"access_token": token,
+			"expires_in":   5000000,
+			"token_type":   "bearer",
+		})
+	case "authorization_code":
+		s.mu.Lock()
+		var token twitchwh.UserToken
+		code, ok := s.codes[r.FormValue("code")]
+		delete(s.codes, r.FormValue("code"))
+		ok = ok && code.redirectURI == r.FormValue("redirect_uri")
+		if ok {
+			token = s.issueUserToken(s.users[code.userID])
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "Invalid authorization code"})
+			return
+		}
+		writeUserToken(w, token)
+	case "refresh_token":
+		s.mu.Lock()
+		var token twitchwh.UserToken
+		u := s.userByRefreshToken(r.FormValue("refresh_token"))
+		if u != nil {
+			token = s.issueUserToken(u)
+		}
+		s.mu.Unlock()
+		if u == nil {
+			writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "Invalid refresh token"})
+			return
+		}
+		writeUserToken(w, token)
+	default:
+		w.WriteHeader(400)
+	}
+}
+
+// Approves the request as AuthorizeUserID, and redirects back to the redirect URI with an authorization code.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") == "" || query.Get("response_type") != "code" || redirect.String() == "" {
+		w.WriteHeader(400)
+		return
+	}
+
+	params := redirect.Query()
+	params.Set("state", query.Get("state"))
+	s.mu.Lock()
+	if s.AuthorizeUserID == "" {
+		params.Set("error", "access_denied")
+		params.Set("error_description", "The user denied you access")
+	} else {
+		u, ok := s.users[s.AuthorizeUserID]
+		if !ok {
+			u = &user{id: s.AuthorizeUserID}
+			s.users[u.id] = u
+		}
+		for _, scope := range strings.Fields(query.Get("scope")) {
+			if !slices.Contains(u.scopes, scope) {
+				u.scopes = append(u.scopes, scope)
+			}
+		}
+		code := randomID()
+		s.codes[code] = authorizationCode{userID: u.id, redirectURI: query.Get("redirect_uri")}
+		params.Set("code", code)
+		params.Set("scope", strings.Join(u.scopes, " "))
+	}
+	s.mu.Unlock()
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func writeUserToken(w http.ResponseWriter, token twitchwh.UserToken) {
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	writeJSON(w, 200, map[string]any{
+		"access_token":  token.AccessToken,
+		"refresh_token": token.RefreshToken,
+		"expires_in":    int(userTokenLifetime.Seconds()),
+		"scope":         scopes,
+		"token_type":    "bearer",
+	})
+}
+
+// Must be called with mu held.
+func (s *Server) userByRefreshToken(refreshToken string) *user {
+	for _, u := range s.users {
+		if refreshToken != "" && u.refreshToken == refreshToken {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := r.Header.Get("Authorization")
+	token = strings.TrimPrefix(strings.TrimPrefix(token, "Bearer "), "OAuth ")
+	if token != "" && token == s.token {
+		writeJSON(w, 200, map[string]any{"client_id": "id", "scopes": []string{}, "expires_in": 5000000})
+		return
+	}
+	for _, u := range s.users {
+		if token != "" && u.accessToken == token {
+			writeJSON(w, 200, map[string]any{
+				"client_id":  "id",
+				"login":      "user" + u.id,
+				"user_id":    u.id,
+				"scopes":     u.scopes,
+				"expires_in": int(userTokenLifetime.Seconds()),
+			})
+			return
+		}
+	}
+	w.WriteHeader(401)
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") == "" {
+		w.WriteHeader(400)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := r.FormValue("token")
+	if token != "" && token == s.token {
+		s.token = ""
+		w.WriteHeader(200)
+		return
+	}
+	for _, u := range s.users {
+		if token != "" && u.accessToken == token {
+			u.accessToken = ""
+			w.WriteHeader(200)
+			return
+		}
+	}
+	writeJSON(w, 400, map[string]any{"status": 400, "message": "Invalid token"})
+}
+
+func (s *Server) validToken(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token != "" && r.Header.Get("Authorization") == "Bearer "+s.token
+}
+
+// authorized responds with 401 Unauthorized if the request does not have the current token.
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.validToken(r) || r.Header.Get("Client-ID") == "" {
+			writeJSON(w, 401, map[string]any{"error": "Unauthorized", "status": 401, "message": "Invalid OAuth token"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Type      string             `json:"type"`
+		Version   string             `json:"version"`
+		Condition twitchwh.Condition `json:"condition"`
+		Transport struct {
+			Method    string `json:"method"`
+			Callback  string `json:"callback"`
+			Secret    string `json:"secret"`
+			ConduitID string `json:"conduit_id"`
+		} `json:"transport"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Type == "" || request.Version == "" {
+		writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "invalid request body"})
+		return
+	}
+	if request.Transport.Method != "webhook" && request.Transport.Method != "conduit" {
+		writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "only the webhook and conduit transports are supported"})
+		return
+	}
+
+	if request.Condition.RewardID != nil {
+		// Twitch returns reward_id as a string, whatever type it was created with
+		request.Condition.RewardID = fmt.Sprint(request.Condition.RewardID)
+	}
+
+	s.mu.Lock()
+	if _, ok := s.conduits[request.Transport.ConduitID]; request.Transport.Method == "conduit" && !ok {
+		s.mu.Unlock()
+		writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "conduit not found"})
+		return
+	}
+	for _, id := range s.order {
+		existing := s.subscriptions[id]
+		if existing.Type == request.Type && existing.Version == request.Version && existing.Condition == request.Condition &&
+			existing.Transport.Callback == request.Transport.Callback && existing.Transport.ConduitID == request.Transport.ConduitID {
+			s.mu.Unlock()
+			writeJSON(w, 409, map[string]any{"error": "Conflict", "status": 409, "message": "subscription already exists"})
+			return
+		}
+	}
+
+	sub := &subscription{secret: request.Transport.Secret}
+	sub.ID = randomID()
+	sub.Status = "webhook_callback_verification_pending"
+	sub.Type = request.Type
+	sub.Version = request.Version
+	sub.Condition = request.Condition
+	// Twitch charges nothing for subscriptions authorized by a user in the condition
+	sub.Cost = 1
+	for _, id := range []string{request.Condition.BroadcasterUserID, request.Condition.ModeratorUserID, request.Condition.UserID} {
+		if _, ok := s.users[id]; ok && id != "" {
+			sub.Cost = 0
+		}
+	}
+	sub.Transport.Method = request.Transport.Method
+	sub.Transport.Callback = request.Transport.Callback
+	sub.Transport.ConduitID = request.Transport.ConduitID
+	if sub.Transport.Method == "conduit" {
+		sub.Status = "enabled"
+	}
+	sub.CreatedAt = time.Now().UTC()
+	s.subscriptions[sub.ID] = sub
+	s.order = append(s.order, sub.ID)
+	copied := *sub
+	total, totalCost := s.totals()
+	s.mu.Unlock()
+
+	writeJSON(w, 202, map[string]any{
+		"data":           []twitchwh.Subscription{copied.Subscription},
+		"total":          total,
+		"total_cost":     totalCost,
+		"max_total_cost": s.MaxTotalCost,
+	})
+	if copied.Transport.Method == "webhook" {
+		go s.verify(copied)
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	var matching []twitchwh.Subscription
+	for _, id := range s.order {
+		sub := s.subscriptions[id].Subscription
+		if query.Has("type") && sub.Type != query.Get("type") {
+			continue
+		}
+		if query.Has("status") && sub.Status != query.Get("status") {
+			continue
+		}
+		if query.Has("subscription_id") && sub.ID != query.Get("subscription_id") {
+			continue
+		}
+		if query.Has("user_id") && sub.Condition.BroadcasterUserID != query.Get("user_id") && sub.Condition.UserID != query.Get("user_id") {
+			continue
+		}
+		matching = append(matching, sub)
+	}
+	total, totalCost := s.totals()
+	s.mu.Unlock()
+
+	data, pagination := paginate(matching, query.Get("after"), s.PageSize)
+	writeJSON(w, 200, map[string]any{
+		"data":           data,
+		"total":          total,
+		"total_cost":     totalCost,
+		"max_total_cost": s.MaxTotalCost,
+		"pagination":     pagination,
+	})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		writeJSON(w, 404, map[string]any{"error": "Not Found", "status": 404, "message": "subscription not found"})
+		return
+	}
+	s.deleteSubscription(id)
+	w.WriteHeader(204)
+}
+
+// Must be called with mu held.
+func (s *Server) deleteSubscription(id string) {
+	delete(s.subscriptions, id)
+	s.order = slices.DeleteFunc(s.order, func(other string) bool { return other == id })
+}
+
+func (s *Server) handleCreateConduit(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ShardCount int `json:"shard_count"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.ShardCount < 1 || request.ShardCount > maxShardCount {
+		writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "invalid shard_count"})
+		return
+	}
+
+	c := &conduit{}
+	c.ID = randomID()
+	c.resize(request.ShardCount)
+	s.mu.Lock()
+	s.conduits[c.ID] = c
+	s.conduitOrder = append(s.conduitOrder, c.ID)
+	copied := c.Conduit
+	s.mu.Unlock()
+	writeJSON(w, 200, map[string]any{"data": []twitchwh.Conduit{copied}})
+}
+
+func (s *Server) handleGetConduits(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	conduits := make([]twitchwh.Conduit, 0, len(s.conduitOrder))
+	for _, id := range s.conduitOrder {
+		conduits = append(conduits, s.conduits[id].Conduit)
+	}
+	s.mu.Unlock()
+	writeJSON(w, 200, map[string]any{"data": conduits})
+}
+
+func (s *Server) handleUpdateConduit(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ID         string `json:"id"`
+		ShardCount int    `json:"shard_count"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.ShardCount < 1 || request.ShardCount > maxShardCount {
+		writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "invalid shard_count"})
+		return
+	}
+
+	s.mu.Lock()
+	c, ok := s.conduits[request.ID]
+	var copied twitchwh.Conduit
+	if ok {
+		c.resize(request.ShardCount)
+		copied = c.Conduit
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, 404, map[string]any{"error": "Not Found", "status": 404, "message": "conduit not found"})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"data": []twitchwh.Conduit{copied}})
+}
+
+// Deletes the conduit along with its subscriptions.
+func (s *Server) handleDeleteConduit(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conduits[id]; !ok {
+		writeJSON(w, 404, map[string]any{"error": "Not Found", "status": 404, "message": "conduit not found"})
+		return
+	}
+	delete(s.conduits, id)
+	s.conduitOrder = slices.DeleteFunc(s.conduitOrder, func(other string) bool { return other == id })
+	for _, subscriptionID := range slices.Clone(s.order) {
+		if s.subscriptions[subscriptionID].Transport.ConduitID == id {
+			s.deleteSubscription(subscriptionID)
+		}
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) handleGetShards(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.mu.Lock()
+	c, ok := s.conduits[query.Get("conduit_id")]
+	var matching []twitchwh.Shard
+	if ok {
+		for _, shard := range c.shards {
+			if query.Has("status") && string(shard.Status) != query.Get("status") {
+				continue
+			}
+			matching = append(matching, shard)
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, 404, map[string]any{"error": "Not Found", "status": 404, "message": "conduit not found"})
+		return
+	}
+
+	data, pagination := paginate(matching, query.Get("after"), s.PageSize)
+	writeJSON(w, 200, map[string]any{
+		"data":       data,
+		"pagination": pagination,
+	})
+}
+
+// Assigns transports to the shards. Shards that can not be updated are returned in errors, like on Twitch.
+func (s *Server) handleUpdateShards(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ConduitID string                 `json:"conduit_id"`
+		Shards    []twitchwh.ShardUpdate `json:"shards"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || len(request.Shards) == 0 {
+		writeJSON(w, 400, map[string]any{"error": "Bad Request", "status": 400, "message": "invalid request body"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.conduits[request.ConduitID]
+	if !ok {
+		writeJSON(w, 404, map[string]any{"error": "Not Found", "status": 404, "message": "conduit not found"})
+		return
+	}
+	updated := []twitchwh.Shard{}
+	errs := []twitchwh.ShardError{}
+	for _, update := range request.Shards {
+		index, err := strconv.Atoi(update.ID)
+		if err != nil || index < 0 || index >= len(c.shards) {
+			errs = append(errs, twitchwh.ShardError{ID: update.ID, Message: "The shard id is outside of the conduit's range", Code: "invalid_parameter"})
+			continue
+		}
+		transport := update.Transport
+		switch {
+		case transport.Method == "webhook" && transport.Callback != "" && len(transport.Secret) >= 10 && len(transport.Secret) <= 100:
+			// Twitch never returns the secret
+			transport.Secret = ""
+		case transport.Method == "websocket" && transport.SessionID != "":
+			now := time.Now().UTC()
+			transport.ConnectedAt = &now
+		default:
+			errs = append(errs, twitchwh.ShardError{ID: update.ID, Message: "Invalid transport", Code: "invalid_parameter"})
+			continue
+		}
+		c.shards[index] = twitchwh.Shard{ID: update.ID, Status: twitchwh.ShardEnabled, Transport: transport}
+		updated = append(updated, c.shards[index])
+	}
+	writeJSON(w, 202, map[string]any{"data": updated, "errors": errs})
+}
+
+// Returns the page of items starting at the after cursor, and the pagination object pointing to the next page.
+func paginate[T any](items []T, after string, pageSize int) ([]T, map[string]string) {
+	offset, _ := strconv.Atoi(after)
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := offset + pageSize
+	pagination := map[string]string{}
+	if end < len(items) {
+		pagination["cursor"] = strconv.Itoa(end)
+	} else {
+		end = len(items)
+	}
+	return items[offset:end], pagination
+}
+
+// Returns the number of subscriptions and their total cost. Must be called with mu held.
+// Only enabled and pending subscriptions count towards the total cost, like on Twitch.
+func (s *Server) totals() (total int, totalCost int) {
+	for _, sub := range s.subscriptions {
+		total++
+		if sub.Status == "enabled" || sub.Status == "webhook_callback_verification_pending" {
+			totalCost += sub.Cost
+		}
+	}
+	return total, totalCost
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func sign(secret string, message string) string {
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte(message))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
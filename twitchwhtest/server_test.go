@@ -0,0 +1,1027 @@
+package twitchwhtest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LinneB/twitchwh"
+	"github.com/LinneB/twitchwh/twitchwhtest"
+)
+
+func newClient(t *testing.T, server *twitchwhtest.Server) *twitchwh.Client {
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:      "id",
+		ClientSecret:  "secret",
+		WebhookSecret: "supersecretstring",
+		WebhookURL:    "https://example.com/eventsub",
+		HelixURL:      server.HelixURL(),
+		OAuthURL:      server.OAuthURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Webhook = http.HandlerFunc(client.Handler)
+	return client
+}
+
+// waitForStatus waits for the server to finish verifying the first subscription.
+// AddSubscription returns as soon as the challenge is answered, which may be before the server has seen the response.
+func waitForStatus(t *testing.T, server *twitchwhtest.Server, status string) twitchwh.Subscription {
+	deadline := time.Now().Add(time.Second)
+	for {
+		sub := server.Subscriptions()[0]
+		if sub.Status == status {
+			return sub
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected status %s, got %s", status, sub.Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAddSubscription(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+
+	events := make(chan twitchwh.StreamOnlineEvent, 1)
+	twitchwh.OnEvent(client, "stream.online", func(event twitchwh.StreamOnlineEvent) {
+		events <- event
+	})
+	revoked := make(chan twitchwh.Subscription, 1)
+	client.OnRevocation = func(sub twitchwh.Subscription) {
+		revoked <- sub
+	}
+
+	condition := twitchwh.Condition{BroadcasterUserID: "215185844"}
+	err := client.AddSubscription("stream.online", "1", condition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := waitForStatus(t, server, "enabled")
+
+	var duplicateErr *twitchwh.DuplicateSubscriptionError
+	err = client.AddSubscription("stream.online", "1", condition)
+	if !errors.As(err, &duplicateErr) {
+		t.Fatalf("Expected DuplicateSubscriptionError, got %v", err)
+	}
+
+	status, err := server.Notify(sub.ID, twitchwh.StreamOnlineEvent{BroadcasterUserLogin: "linneb"})
+	if err != nil || status != 204 {
+		t.Fatalf("Expected 204, got %d %v", status, err)
+	}
+	select {
+	case event := <-events:
+		if event.BroadcasterUserLogin != "linneb" {
+			t.Fatalf("Unexpected event %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+
+	status, err = server.Revoke(sub.ID, "user_removed")
+	if err != nil || status != 204 {
+		t.Fatalf("Expected 204, got %d %v", status, err)
+	}
+	if revokedSub := <-revoked; revokedSub.Status != "user_removed" {
+		t.Fatalf("Expected status user_removed, got %s", revokedSub.Status)
+	}
+}
+
+// newReplica creates a client that shares the dedup store with other replicas.
+func newReplica(t *testing.T, server *twitchwhtest.Server, store *twitchwhtest.DedupStore) *twitchwh.Client {
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:      "id",
+		ClientSecret:  "secret",
+		WebhookSecret: "supersecretstring",
+		WebhookURL:    "https://example.com/eventsub",
+		HelixURL:      server.HelixURL(),
+		OAuthURL:      server.OAuthURL(),
+		DedupStore:    store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestSharedDedupStore(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	store := twitchwhtest.NewDedupStore()
+	replicas := []*twitchwh.Client{newReplica(t, server, store), newReplica(t, server, store)}
+	events := make(chan int, 10)
+	for i, replica := range replicas {
+		replica.On("stream.online", func(event json.RawMessage) {
+			events <- i
+		})
+	}
+
+	server.Webhook = http.HandlerFunc(replicas[0].Handler)
+	sub, err := replicas[0].CreateSubscription(context.Background(), "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := server.Send(twitchwhtest.Message{ID: "redelivered", SubscriptionID: sub.ID, Event: twitchwh.StreamOnlineEvent{}})
+	if err != nil || status != 204 {
+		t.Fatalf("Expected 204, got %d %v", status, err)
+	}
+	if replica := <-events; replica != 0 {
+		t.Fatalf("Expected the event to be handled by replica 0, got %d", replica)
+	}
+
+	// The load balancer sends the retry to the other replica
+	server.Webhook = http.HandlerFunc(replicas[1].Handler)
+	status, err = server.Send(twitchwhtest.Message{ID: "redelivered", Retry: 1, SubscriptionID: sub.ID, Event: twitchwh.StreamOnlineEvent{}})
+	if err != nil || status != 204 {
+		t.Fatalf("Expected 204, got %d %v", status, err)
+	}
+	select {
+	case replica := <-events:
+		t.Fatalf("Redelivered event was handled again by replica %d", replica)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if calls := store.Calls(); len(calls) != 2 || calls[0] != "redelivered" || calls[1] != "redelivered" {
+		t.Fatalf("Unexpected MarkHandled calls %v", calls)
+	}
+}
+
+func TestDedupStoreError(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	store := twitchwhtest.NewDedupStore()
+	client := newReplica(t, server, store)
+	server.Webhook = http.HandlerFunc(client.Handler)
+	events := make(chan json.RawMessage, 1)
+	client.On("stream.online", func(event json.RawMessage) {
+		events <- event
+	})
+
+	sub, err := client.CreateSubscription(context.Background(), "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Err = errors.New("store is down")
+	status, err := server.Send(twitchwhtest.Message{ID: "1", SubscriptionID: sub.ID, Event: twitchwh.StreamOnlineEvent{}})
+	if err != nil || status != 500 {
+		t.Fatalf("Expected 500, got %d %v", status, err)
+	}
+	select {
+	case <-events:
+		t.Fatal("Event was handled without being marked as handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Twitch retries the event once the store is back
+	store.Err = nil
+	status, err = server.Send(twitchwhtest.Message{ID: "1", Retry: 1, SubscriptionID: sub.ID, Event: twitchwh.StreamOnlineEvent{}})
+	if err != nil || status != 204 {
+		t.Fatalf("Expected 204, got %d %v", status, err)
+	}
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for retried event")
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+
+	err := client.AddSubscription("stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ExpireToken()
+	subs, err := client.GetSubscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 {
+		t.Fatalf("Expected 1 subscription, got %d", len(subs))
+	}
+	err = client.RemoveSubscription(subs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerificationFailure(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	server.Webhook = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode("not the challenge")
+	})
+
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:            "id",
+		ClientSecret:        "secret",
+		WebhookSecret:       "supersecretstring",
+		HelixURL:            server.HelixURL(),
+		OAuthURL:            server.OAuthURL(),
+		VerificationTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var timeoutErr *twitchwh.VerificationTimeoutError
+	err = client.AddSubscription("stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected VerificationTimeoutError, got %v", err)
+	}
+	waitForStatus(t, server, "webhook_callback_verification_failed")
+}
+
+func TestCreateSubscription(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+
+	sub, err := client.CreateSubscription(context.Background(), "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.ID != server.Subscriptions()[0].ID || sub.Status != "enabled" {
+		t.Fatalf("Unexpected subscription %+v", sub)
+	}
+
+	err = client.RemoveSubscriptionContext(context.Background(), sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Subscriptions()) != 0 {
+		t.Fatal("Subscription was not removed")
+	}
+}
+
+func TestSubscriptionQuota(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	server.MaxTotalCost = 1
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	sub, err := client.CreateSubscription(ctx, "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota := client.Quota(); quota != (twitchwh.SubscriptionQuota{Total: 1, TotalCost: 1, MaxTotalCost: 1}) {
+		t.Fatalf("Unexpected quota after create %+v", quota)
+	}
+
+	var quotaErr *twitchwh.QuotaExceededError
+	_, err = client.CreateSubscription(ctx, "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "2"})
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("Expected QuotaExceededError, got %v", err)
+	}
+	if len(server.Subscriptions()) != 1 {
+		t.Fatal("Subscription over quota was sent to the server")
+	}
+
+	err = client.RemoveSubscriptionContext(ctx, sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota := client.Quota(); quota != (twitchwh.SubscriptionQuota{Total: 0, TotalCost: 0, MaxTotalCost: 1}) {
+		t.Fatalf("Unexpected quota after remove %+v", quota)
+	}
+	quota, err := client.GetSubscriptionQuota(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota != client.Quota() {
+		t.Fatalf("Fetched quota %+v does not match tracked quota %+v", quota, client.Quota())
+	}
+}
+
+func TestSubscriptionQuotaUserAuthorized(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	server.MaxTotalCost = 1
+	client := newClient(t, server)
+	ctx := context.Background()
+	server.AddUser("1", "channel:read:subscriptions")
+
+	_, err := client.CreateSubscription(ctx, "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota := client.Quota(); quota.TotalCost != quota.MaxTotalCost {
+		t.Fatalf("Expected the quota to be full, got %+v", quota)
+	}
+
+	// Subscriptions authorized by the user cost nothing, so Helix accepts them at the cap
+	sub, err := client.CreateSubscription(ctx, "channel.subscribe", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatalf("Expected a cost 0 subscription to be created at the cap, got %v", err)
+	}
+	if sub.Cost != 0 {
+		t.Fatalf("Expected cost 0, got %d", sub.Cost)
+	}
+
+	var quotaErr *twitchwh.QuotaExceededError
+	_, err = client.CreateSubscription(ctx, "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "3"})
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("Expected QuotaExceededError, got %v", err)
+	}
+}
+
+func TestConduits(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	conduit, err := client.CreateConduit(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conduit.ID == "" || conduit.ShardCount != 2 {
+		t.Fatalf("Unexpected conduit %+v", conduit)
+	}
+	conduits, err := client.GetConduits(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conduits) != 1 || conduits[0] != conduit {
+		t.Fatalf("Unexpected conduits %+v", conduits)
+	}
+
+	conduit, err = client.UpdateConduit(ctx, conduit.ID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conduit.ShardCount != 3 || len(server.Shards(conduit.ID)) != 3 {
+		t.Fatalf("Conduit was not resized %+v", conduit)
+	}
+
+	err = client.DeleteConduit(ctx, conduit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conduits, err = client.GetConduits(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conduits) != 0 {
+		t.Fatal("Conduit was not deleted")
+	}
+}
+
+func TestConduitNotFound(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	var notFound *twitchwh.ConduitNotFoundError
+	_, err := client.UpdateConduit(ctx, "missing", 1)
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Expected ConduitNotFoundError from UpdateConduit, got %v", err)
+	}
+	err = client.DeleteConduit(ctx, "missing")
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Expected ConduitNotFoundError from DeleteConduit, got %v", err)
+	}
+	_, err = client.GetConduitShards(ctx, "missing")
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Expected ConduitNotFoundError from GetConduitShards, got %v", err)
+	}
+	_, err = client.UpdateConduitShards(ctx, "missing", []twitchwh.ShardUpdate{client.WebhookShard("0")})
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Expected ConduitNotFoundError from UpdateConduitShards, got %v", err)
+	}
+}
+
+func TestConduitShards(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	server.PageSize = 2
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	conduit, err := client.CreateConduit(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, err := client.GetConduitShards(ctx, conduit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 5 {
+		t.Fatalf("Expected 5 shards over 3 pages, got %d", len(shards))
+	}
+	for i, shard := range shards {
+		if shard.ID != strconv.Itoa(i) {
+			t.Fatalf("Expected shard %d, got %s", i, shard.ID)
+		}
+	}
+
+	updated, err := client.UpdateConduitShards(ctx, conduit.ID, []twitchwh.ShardUpdate{
+		client.WebhookShard("1"),
+		client.WebhookShard("2"),
+		client.WebhookShard("4"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 3 || updated[0].Transport.Callback != "https://example.com/eventsub" || updated[0].Transport.Secret != "" {
+		t.Fatalf("Unexpected updated shards %+v", updated)
+	}
+	enabled, err := client.GetConduitShardsByStatus(ctx, conduit.ID, twitchwh.ShardEnabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enabled) != 3 || enabled[0].ID != "1" || enabled[1].ID != "2" || enabled[2].ID != "4" {
+		t.Fatalf("Unexpected enabled shards %+v", enabled)
+	}
+}
+
+func TestUpdateConduitShardsPartialFailure(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	conduit, err := client.CreateConduit(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := client.UpdateConduitShards(ctx, conduit.ID, []twitchwh.ShardUpdate{
+		client.WebhookShard("0"),
+		client.WebhookShard("5"),
+	})
+	var shardErr *twitchwh.ShardUpdateError
+	if !errors.As(err, &shardErr) {
+		t.Fatalf("Expected ShardUpdateError, got %v", err)
+	}
+	if len(shardErr.Errors) != 1 || shardErr.Errors[0].ID != "5" || shardErr.Errors[0].Code != "invalid_parameter" {
+		t.Fatalf("Unexpected shard errors %+v", shardErr.Errors)
+	}
+	if len(updated) != 1 || updated[0].ID != "0" {
+		t.Fatalf("Expected the successful update to be returned, got %+v", updated)
+	}
+	if shards := server.Shards(conduit.ID); shards[0].Status != twitchwh.ShardEnabled || shards[1].Status != "" {
+		t.Fatalf("Unexpected shards %+v", shards)
+	}
+}
+
+func TestAddConduitSubscription(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	conduit, err := client.CreateConduit(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := client.AddConduitSubscription(ctx, conduit.ID, "stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := server.Subscriptions()[0]
+	if stored.ID != sub.ID || stored.Transport.Method != "conduit" || stored.Transport.ConduitID != conduit.ID || stored.Transport.Callback != "" {
+		t.Fatalf("Unexpected subscription transport %+v", stored.Transport)
+	}
+	if sub.Status != "enabled" {
+		t.Fatalf("Expected conduit subscription to be enabled, got %s", sub.Status)
+	}
+
+	err = client.DeleteConduit(ctx, conduit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Subscriptions()) != 0 {
+		t.Fatal("Subscription was not removed with the conduit")
+	}
+}
+
+// staleProvider hands out a token the server does not know, until it is refreshed.
+type staleProvider struct {
+	mu        sync.Mutex
+	token     string
+	refreshes int
+	source    *twitchwh.ClientCredentials
+}
+
+func (p *staleProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.token, nil
+}
+
+func (p *staleProvider) Refresh(ctx context.Context, rejected string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refreshes++
+	token, err := p.source.Token(ctx)
+	p.token = token
+	return token, err
+}
+
+func TestTokenProvider(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	provider := &staleProvider{
+		token:  "stale",
+		source: &twitchwh.ClientCredentials{ClientID: "id", ClientSecret: "secret", OAuthURL: server.OAuthURL()},
+	}
+
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:      "id",
+		TokenProvider: provider,
+		HelixURL:      server.HelixURL(),
+		OAuthURL:      server.OAuthURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetSubscriptionsContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.refreshes != 1 {
+		t.Fatalf("Expected 1 refresh, got %d", provider.refreshes)
+	}
+
+	client, err = twitchwh.New(twitchwh.ClientConfig{
+		ClientID:      "id",
+		TokenProvider: twitchwh.StaticToken("stale"),
+		HelixURL:      server.HelixURL(),
+		OAuthURL:      server.OAuthURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uaErr *twitchwh.UnauthorizedError
+	_, err = client.GetSubscriptionsContext(context.Background())
+	if !errors.As(err, &uaErr) {
+		t.Fatalf("Expected UnauthorizedError, got %v", err)
+	}
+}
+
+func TestUserToken(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	issued := server.AddUser("1", "user:read:chat")
+	token, err := client.AddUserToken(ctx, issued.AccessToken, issued.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserID != "1" || !token.HasScope("user:read:chat") {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+
+	var scopeErr *twitchwh.MissingScopeError
+	_, err = client.CreateSubscription(ctx, "channel.subscribe", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if !errors.As(err, &scopeErr) || scopeErr.UserID != "1" || scopeErr.Scopes[0] != "channel:read:subscriptions" {
+		t.Fatalf("Expected MissingScopeError, got %v", err)
+	}
+	if len(server.Subscriptions()) != 0 {
+		t.Fatal("Subscription with missing scopes was sent to the server")
+	}
+
+	var refreshed []twitchwh.UserToken
+	client.OnUserTokenRefresh = func(token twitchwh.UserToken) {
+		refreshed = append(refreshed, token)
+	}
+	token.Expiry = time.Now()
+	client.SetUserToken(token)
+	token, err = client.UserToken(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken == issued.AccessToken || token.RefreshToken == issued.RefreshToken || token.UserID != "1" {
+		t.Fatalf("Token was not refreshed %+v", token)
+	}
+	if len(refreshed) != 1 || refreshed[0].AccessToken != token.AccessToken {
+		t.Fatalf("Expected OnUserTokenRefresh with the new token, got %v", refreshed)
+	}
+
+	var notFoundErr *twitchwh.UserTokenNotFoundError
+	client.RemoveUserToken("1")
+	_, err = client.UserToken(ctx, "1")
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("Expected UserTokenNotFoundError, got %v", err)
+	}
+}
+
+// authorize runs the authorization code flow against the server, and returns the response of the callback.
+func authorize(t *testing.T, authorizer *twitchwh.Authorizer, cookieState string) *http.Response {
+	w := httptest.NewRecorder()
+	authorizer.Redirect(w, httptest.NewRequest("GET", "/auth", nil))
+	redirect := w.Result()
+	cookies := redirect.Cookies()
+	if redirect.StatusCode != 302 || len(cookies) != 1 {
+		t.Fatalf("Expected redirect with state cookie, got %d %v", redirect.StatusCode, cookies)
+	}
+	if cookieState != "" {
+		cookies[0].Value = cookieState
+	}
+
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := noRedirects.Get(redirect.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback := httptest.NewRequest("GET", res.Header.Get("Location"), nil)
+	callback.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	authorizer.Callback(w, callback)
+	result := w.Result()
+
+	// The state is only valid once, so the callback must delete the same cookie
+	cleared := result.Cookies()
+	if len(cleared) != 1 || cleared[0].Name != cookies[0].Name || cleared[0].MaxAge >= 0 || cleared[0].Path != cookies[0].Path ||
+		cleared[0].Secure != cookies[0].Secure || cleared[0].SameSite != cookies[0].SameSite {
+		t.Fatalf("Expected callback to delete state cookie %v, got %v", cookies[0], cleared)
+	}
+	return result
+}
+
+func TestAuthorizer(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	server.AuthorizeUserID = "1"
+	client := newClient(t, server)
+
+	authorized := make(chan twitchwh.UserToken, 1)
+	var created []twitchwh.Subscription
+	authorizer := client.NewAuthorizer(twitchwh.AuthorizerConfig{
+		RedirectURL: "https://example.com/auth/callback",
+		Scopes:      twitchwh.RequiredScopes("channel.subscribe")[:1],
+		Subscriptions: func(userID string) []twitchwh.SubscriptionSpec {
+			return []twitchwh.SubscriptionSpec{
+				{Type: "channel.subscribe", Version: "1", Condition: twitchwh.Condition{BroadcasterUserID: userID}},
+			}
+		},
+		OnAuthorized: func(w http.ResponseWriter, r *http.Request, token twitchwh.UserToken, subscriptions []twitchwh.Subscription, err error) {
+			if err != nil {
+				t.Errorf("Could not create subscriptions: %s", err)
+			}
+			created = subscriptions
+			authorized <- token
+		},
+	})
+
+	res := authorize(t, authorizer, "")
+	if res.StatusCode != 200 {
+		t.Fatalf("Expected 200, got %d", res.StatusCode)
+	}
+	if cookie := res.Cookies()[0]; cookie.Path != "/auth/callback" || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Unexpected state cookie attributes %v", cookie)
+	}
+	token := <-authorized
+	if token.UserID != "1" || !token.HasScope("channel:read:subscriptions") || token.RefreshToken == "" {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+	if _, err := client.UserToken(context.Background(), "1"); err != nil {
+		t.Fatalf("Token was not added to the client: %s", err)
+	}
+	if len(created) != 1 || created[0].Status != "enabled" || created[0].Condition.BroadcasterUserID != "1" {
+		t.Fatalf("Unexpected subscriptions %+v", created)
+	}
+}
+
+func TestAuthorizerSubscriptionsConcurrently(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	server.AuthorizeUserID = "1"
+	client := newClient(t, server)
+	// Every verification takes a while, like over a slow connection
+	server.Webhook = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Twitch-Eventsub-Message-Type") == "webhook_callback_verification" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		client.Handler(w, r)
+	})
+
+	types := []string{"channel.subscribe", "channel.subscription.end", "channel.subscription.gift", "channel.subscription.message"}
+	var created []twitchwh.Subscription
+	authorizer := client.NewAuthorizer(twitchwh.AuthorizerConfig{
+		RedirectURL: "https://example.com/auth/callback",
+		Scopes:      twitchwh.RequiredScopes("channel.subscribe"),
+		Subscriptions: func(userID string) []twitchwh.SubscriptionSpec {
+			var specs []twitchwh.SubscriptionSpec
+			for _, Type := range types {
+				specs = append(specs, twitchwh.SubscriptionSpec{Type: Type, Version: "1", Condition: twitchwh.Condition{BroadcasterUserID: userID}})
+			}
+			return specs
+		},
+		OnAuthorized: func(w http.ResponseWriter, r *http.Request, token twitchwh.UserToken, subscriptions []twitchwh.Subscription, err error) {
+			if err != nil {
+				t.Errorf("Could not create subscriptions: %s", err)
+			}
+			created = subscriptions
+		},
+	})
+
+	start := time.Now()
+	authorize(t, authorizer, "")
+	if elapsed := time.Since(start); elapsed > time.Duration(len(types)-1)*300*time.Millisecond {
+		t.Fatalf("Expected subscriptions to be verified concurrently, took %s", elapsed)
+	}
+	if len(created) != len(types) {
+		t.Fatalf("Expected %d subscriptions, got %d", len(types), len(created))
+	}
+	for i, sub := range created {
+		if sub.Type != types[i] {
+			t.Fatalf("Expected subscriptions in the configured order, got %s at %d", sub.Type, i)
+		}
+	}
+}
+
+func TestAuthorizerErrors(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+
+	errs := make(chan error, 1)
+	authorizer := client.NewAuthorizer(twitchwh.AuthorizerConfig{
+		RedirectURL: "https://example.com/auth/callback",
+		OnError: func(w http.ResponseWriter, r *http.Request, err error) {
+			errs <- err
+			w.WriteHeader(403)
+		},
+	})
+
+	var authErr *twitchwh.AuthorizationError
+	authorize(t, authorizer, "")
+	if err := <-errs; !errors.As(err, &authErr) || authErr.Code != "access_denied" {
+		t.Fatalf("Expected access_denied, got %v", err)
+	}
+
+	server.AuthorizeUserID = "1"
+	res := authorize(t, authorizer, "forged")
+	if err := <-errs; !errors.As(err, &authErr) || authErr.Code != "invalid_state" {
+		t.Fatalf("Expected invalid_state, got %v", err)
+	}
+	if res.StatusCode != 403 {
+		t.Fatalf("Expected response from OnError, got %d", res.StatusCode)
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:           "id",
+		ClientSecret:       "secret",
+		WebhookSecret:      "supersecretstring",
+		WebhookURL:         "https://example.com/eventsub",
+		HelixURL:           server.HelixURL(),
+		OAuthURL:           server.OAuthURL(),
+		RevokeTokenOnClose: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Webhook = http.HandlerFunc(client.Handler)
+	// The server issues the same app access token to every client credentials request
+	res, err := http.PostForm(server.OAuthURL()+"/token", map[string][]string{
+		"client_id":     {"id"},
+		"client_secret": {"secret"},
+		"grant_type":    {"client_credentials"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.NewDecoder(res.Body).Decode(&token)
+	res.Body.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var finished bool
+	client.On("stream.online", func(event json.RawMessage) {
+		close(started)
+		<-release
+		finished = true
+	})
+	err = client.AddSubscription("stream.online", "1", twitchwh.Condition{BroadcasterUserID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := waitForStatus(t, server, "enabled")
+	server.Notify(sub.ID, twitchwh.StreamOnlineEvent{})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = client.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Close to time out waiting for the handler, got %v", err)
+	}
+	status, err := server.Notify(sub.ID, twitchwh.StreamOnlineEvent{})
+	if err != nil || status != 503 {
+		t.Fatalf("Expected 503 after Close, got %d (%v)", status, err)
+	}
+
+	close(release)
+	err = client.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !finished {
+		t.Fatal("Close returned before the handler finished")
+	}
+	req, _ := http.NewRequest("GET", server.OAuthURL()+"/validate", nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 401 {
+		t.Fatalf("Expected the token to be revoked, got %d", res.StatusCode)
+	}
+}
+
+// removalCheck calls check before a subscription is removed.
+type removalCheck func(id string)
+
+func (check removalCheck) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == "DELETE" && strings.HasSuffix(r.URL.Path, "/eventsub/subscriptions") {
+		check(r.URL.Query().Get("id"))
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestRotateWebhookSecret(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	client, err := twitchwh.New(twitchwh.ClientConfig{
+		ClientID:      "id",
+		ClientSecret:  "secret",
+		WebhookSecret: "supersecretstring",
+		WebhookURL:    "https://example.com/eventsub",
+		HelixURL:      server.HelixURL(),
+		OAuthURL:      server.OAuthURL(),
+		HTTPClient: &http.Client{Transport: removalCheck(func(id string) {
+			// A subscription may only be removed once its replacement receives events
+			subs := server.Subscriptions()
+			var removed twitchwh.Subscription
+			for _, sub := range subs {
+				if sub.ID == id {
+					removed = sub
+				}
+			}
+			for _, sub := range subs {
+				if sub.ID != id && sub.Type == removed.Type && sub.Condition == removed.Condition && sub.Status == "enabled" {
+					return
+				}
+			}
+			t.Errorf("Subscription %s was removed before its replacement was enabled", id)
+		})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Webhook = http.HandlerFunc(client.Handler)
+	events := make(chan string, 2)
+	twitchwh.OnEvent(client, "stream.online", func(event twitchwh.StreamOnlineEvent) {
+		events <- event.BroadcasterUserID
+	})
+
+	var desired []twitchwh.SubscriptionSpec
+	for _, id := range []string{"1", "2"} {
+		err := client.AddSubscription("stream.online", "1", twitchwh.Condition{BroadcasterUserID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		desired = append(desired, twitchwh.SubscriptionSpec{Type: "stream.online", Version: "1", Condition: twitchwh.Condition{BroadcasterUserID: id}})
+	}
+	old := server.Subscriptions()
+
+	recreated, err := client.RotateWebhookSecret(context.Background(), "newsupersecretstring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recreated) != 2 {
+		t.Fatalf("Expected 2 recreated subscriptions, got %d", len(recreated))
+	}
+	subs := server.Subscriptions()
+	if len(subs) != 2 || subs[0].ID == old[0].ID || subs[1].ID == old[1].ID {
+		t.Fatalf("Expected subscriptions to be replaced, got %v", subs)
+	}
+	for _, sub := range recreated {
+		if !strings.HasPrefix(sub.Transport.Callback, "https://example.com/eventsub?twitchwh_secret=") {
+			t.Fatalf("Expected the callback to identify the secret, got %s", sub.Transport.Callback)
+		}
+		status, err := server.Notify(sub.ID, twitchwh.StreamOnlineEvent{BroadcasterUserID: sub.Condition.BroadcasterUserID})
+		if err != nil || status != 204 {
+			t.Fatalf("Expected 204 for event signed with the new secret, got %d (%v)", status, err)
+		}
+		if got := <-events; got != sub.Condition.BroadcasterUserID {
+			t.Fatalf("Expected event for %s, got %s", sub.Condition.BroadcasterUserID, got)
+		}
+	}
+
+	// Nothing left to recreate
+	recreated, err = client.RotateWebhookSecret(context.Background(), "newsupersecretstring")
+	if err != nil || len(recreated) != 0 {
+		t.Fatalf("Expected nothing to be recreated, got %v (%v)", recreated, err)
+	}
+	// Reconcile treats the callbacks of the copies as the WebhookURL
+	plan, err := client.Reconcile(context.Background(), desired, twitchwh.ReconcileOptions{DryRun: true})
+	if err != nil || len(plan.Kept) != 2 || len(plan.Created) != 0 || len(plan.Removed) != 0 {
+		t.Fatalf("Expected the copies to be kept, got %+v (%v)", plan, err)
+	}
+}
+
+func TestRotateWebhookSecretRetry(t *testing.T) {
+	server := twitchwhtest.NewServer()
+	defer server.Close()
+	config := twitchwh.ClientConfig{
+		ClientID:            "id",
+		ClientSecret:        "secret",
+		WebhookSecret:       "supersecretstring",
+		WebhookURL:          "https://example.com/eventsub",
+		HelixURL:            server.HelixURL(),
+		OAuthURL:            server.OAuthURL(),
+		VerificationTimeout: 200 * time.Millisecond,
+	}
+	client, err := twitchwh.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fails the verification of subscriptions for broadcaster 2 while set
+	var failing atomic.Bool
+	server.Webhook = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if failing.Load() && r.Header.Get("Twitch-Eventsub-Message-Type") == "webhook_callback_verification" &&
+			strings.Contains(string(body), `"broadcaster_user_id":"2"`) {
+			w.WriteHeader(500)
+			return
+		}
+		client.Handler(w, r)
+	})
+
+	for _, id := range []string{"1", "2"} {
+		err := client.AddSubscription("stream.online", "1", twitchwh.Condition{BroadcasterUserID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := server.Subscriptions()
+
+	failing.Store(true)
+	var timeoutErr *twitchwh.VerificationTimeoutError
+	recreated, err := client.RotateWebhookSecret(context.Background(), "newsupersecretstring")
+	if !errors.As(err, &timeoutErr) || len(recreated) != 1 || recreated[0].Condition.BroadcasterUserID != "1" {
+		t.Fatalf("Expected only the first subscription to be recreated, got %v (%v)", recreated, err)
+	}
+	// The subscription whose copy failed is kept, and still accepts the previous secret
+	status, err := server.Notify(old[1].ID, twitchwh.StreamOnlineEvent{BroadcasterUserID: "2"})
+	if err != nil || status != 204 {
+		t.Fatalf("Expected 204 for event signed with the previous secret, got %d (%v)", status, err)
+	}
+
+	failing.Store(false)
+	retried, err := client.RotateWebhookSecret(context.Background(), "newsupersecretstring")
+	if err != nil || len(retried) != 1 || retried[0].Condition.BroadcasterUserID != "2" {
+		t.Fatalf("Expected the retry to only recreate the second subscription, got %v (%v)", retried, err)
+	}
+	subs := server.Subscriptions()
+	if len(subs) != 2 || subs[0].ID != recreated[0].ID || subs[1].ID != retried[0].ID {
+		t.Fatalf("Expected the old subscriptions and the failed copy to be removed, got %v", subs)
+	}
+
+	// The copies are recognized by their callback, so a restarted client has nothing left to do
+	config.WebhookSecret = "newsupersecretstring"
+	config.PreviousWebhookSecrets = []string{"supersecretstring"}
+	restarted, err := twitchwh.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Webhook = http.HandlerFunc(restarted.Handler)
+	recreated, err = restarted.RotateWebhookSecret(context.Background(), "newsupersecretstring")
+	if err != nil || len(recreated) != 0 {
+		t.Fatalf("Expected the restarted client to recreate nothing, got %v (%v)", recreated, err)
+	}
+}
@@ -0,0 +1,237 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// User access tokens are refreshed when they expire within this duration
+const userTokenRefreshMargin = time.Minute
+
+// UserToken is a user access token managed by the client.
+// See: https://dev.twitch.tv/docs/authentication/#user-access-tokens
+type UserToken struct {
+	// ID of the user that the token belongs to
+	UserID       string
+	AccessToken  string
+	RefreshToken string
+	// Scopes granted by the user
+	Scopes []string
+	// When AccessToken expires. Zero if unknown
+	Expiry time.Time
+}
+
+// HasScope reports whether the user granted the scope.
+func (t UserToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// SetUserToken adds a user access token to the client, replacing any token for the same user.
+//
+// User access tokens are used for WebSocket subscriptions without a WebSocketConfig.UserToken, and to check that
+// the user has granted the scopes needed for a subscription before creating it (see [MissingScopeError]).
+// They are refreshed with their refresh token once they expire, which requires ClientConfig.ClientSecret.
+// Since Twitch rotates refresh tokens, set Client.OnUserTokenRefresh to persist the new tokens.
+func (c *Client) SetUserToken(token UserToken) {
+	c.userTokensMu.Lock()
+	defer c.userTokensMu.Unlock()
+	c.userTokens[token.UserID] = token
+}
+
+// AddUserToken validates a user access token, and adds it to the client along with its user ID, scopes, and expiry.
+// Returns [UnauthorizedError] if the token is not valid. See [Client.SetUserToken].
+func (c *Client) AddUserToken(ctx context.Context, accessToken string, refreshToken string) (UserToken, error) {
+	token, err := c.validateUserToken(ctx, accessToken)
+	if err != nil {
+		return UserToken{}, err
+	}
+	token.RefreshToken = refreshToken
+	c.SetUserToken(token)
+	return token, nil
+}
+
+// RemoveUserToken removes the user access token of the user from the client.
+func (c *Client) RemoveUserToken(userID string) {
+	c.userTokensMu.Lock()
+	defer c.userTokensMu.Unlock()
+	delete(c.userTokens, userID)
+}
+
+// UserToken returns the user access token of the user, refreshing it first if it has expired or is about to.
+// Returns [UserTokenNotFoundError] if the client has no token for the user.
+func (c *Client) UserToken(ctx context.Context, userID string) (UserToken, error) {
+	c.userTokensMu.Lock()
+	defer c.userTokensMu.Unlock()
+	token, ok := c.userTokens[userID]
+	if !ok {
+		return UserToken{}, &UserTokenNotFoundError{UserID: userID}
+	}
+	if token.Expiry.IsZero() || time.Until(token.Expiry) > userTokenRefreshMargin {
+		return token, nil
+	}
+	return c.refreshUserToken(ctx, token)
+}
+
+// Refreshes the token of the user after rejected was rejected by Helix, unless it was already refreshed since.
+func (c *Client) replaceUserToken(ctx context.Context, userID string, rejected string) (UserToken, error) {
+	c.userTokensMu.Lock()
+	defer c.userTokensMu.Unlock()
+	token, ok := c.userTokens[userID]
+	if !ok {
+		return UserToken{}, &UserTokenNotFoundError{UserID: userID}
+	}
+	if token.AccessToken != rejected {
+		return token, nil
+	}
+	return c.refreshUserToken(ctx, token)
+}
+
+// Exchanges the refresh token for a new token, and stores it. Must be called with userTokensMu held,
+// so that a refresh token is never used twice.
+func (c *Client) refreshUserToken(ctx context.Context, token UserToken) (UserToken, error) {
+	c.logger.Info("Refreshing user token", "user_id", token.UserID)
+	refreshed, err := c.requestUserToken(ctx, url.Values{
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+	if err != nil {
+		return UserToken{}, err
+	}
+	refreshed.UserID = token.UserID
+	c.userTokens[token.UserID] = refreshed
+	c.metrics.TokenRefresh("user")
+	if c.OnUserTokenRefresh != nil {
+		c.OnUserTokenRefresh(refreshed)
+	}
+	return refreshed, nil
+}
+
+// Sends a request to the token endpoint that returns a user access token, eg. the refresh_token grant.
+// The UserID of the returned token is not set.
+// Returns [UnauthorizedError] if Twitch rejects the grant.
+func (c *Client) requestUserToken(ctx context.Context, values url.Values) (UserToken, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.oauthURL+"/token", strings.NewReader(values.Encode()))
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not create request", err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := traceRequest(c.tracer, req, c.httpClient.Do)
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not send request", err}
+	}
+
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not read response body", err}
+	}
+
+	// Twitch responds with 400 to invalid refresh tokens and authorization codes
+	if res.StatusCode == 400 || res.StatusCode == 401 {
+		return UserToken{}, &UnauthorizedError{}
+	}
+	if res.StatusCode != 200 {
+		return UserToken{}, &UnhandledStatusError{res.StatusCode, body}
+	}
+
+	var jsonBody struct {
+		AccessToken  string   `json:"access_token"`
+		RefreshToken string   `json:"refresh_token"`
+		ExpiresIn    int      `json:"expires_in"`
+		Scope        []string `json:"scope"`
+	}
+	err = json.Unmarshal(body, &jsonBody)
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not parse response body", err}
+	}
+
+	token := UserToken{
+		AccessToken:  jsonBody.AccessToken,
+		RefreshToken: jsonBody.RefreshToken,
+		Scopes:       jsonBody.Scope,
+	}
+	if jsonBody.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(jsonBody.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// Returns the user ID, scopes, and expiry of a user access token.
+func (c *Client) validateUserToken(ctx context.Context, accessToken string) (UserToken, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.oauthURL+"/validate", nil)
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not create request", err}
+	}
+	req.Header.Set("Authorization", "OAuth "+accessToken)
+
+	res, err := traceRequest(c.tracer, req, c.httpClient.Do)
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not send request", err}
+	}
+
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not read response body", err}
+	}
+
+	if res.StatusCode == 401 {
+		return UserToken{}, &UnauthorizedError{}
+	}
+	if res.StatusCode != 200 {
+		return UserToken{}, &UnhandledStatusError{res.StatusCode, body}
+	}
+
+	var jsonBody struct {
+		UserID    string   `json:"user_id"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int      `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &jsonBody)
+	if err != nil {
+		return UserToken{}, &InternalError{"Could not parse response body", err}
+	}
+	if jsonBody.UserID == "" {
+		return UserToken{}, &InternalError{"Token is not a user access token", nil}
+	}
+
+	token := UserToken{
+		UserID:      jsonBody.UserID,
+		AccessToken: accessToken,
+		Scopes:      jsonBody.Scopes,
+	}
+	if jsonBody.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(jsonBody.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// Internal function that runs request with the user access token of the user,
+// and runs it again with a refreshed token if it returned [UnauthorizedError].
+func (c *Client) retryUserUnauthorized(ctx context.Context, userID string, request func(token string) error) error {
+	token, err := c.UserToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = request(token.AccessToken)
+	var uaErr *UnauthorizedError
+	if errors.As(err, &uaErr) {
+		c.logger.Info("User token invalid, refreshing", "user_id", userID)
+		token, err := c.replaceUserToken(ctx, userID, token.AccessToken)
+		if err != nil {
+			return err
+		}
+		return request(token.AccessToken)
+	}
+	return err
+}
@@ -0,0 +1,388 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const webSocketURL = "wss://eventsub.wss.twitch.tv/ws"
+
+// Message types only sent over the WebSocket transport
+// See: https://dev.twitch.tv/docs/eventsub/handling-websocket-events/
+const messageTypeWelcome = "session_welcome"
+const messageTypeKeepalive = "session_keepalive"
+const messageTypeReconnect = "session_reconnect"
+
+type webSocketSession struct {
+	ID                      string `json:"id"`
+	Status                  string `json:"status"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+type webSocketMessage struct {
+	Metadata struct {
+		MessageID        string    `json:"message_id"`
+		MessageType      string    `json:"message_type"`
+		MessageTimestamp time.Time `json:"message_timestamp"`
+	} `json:"metadata"`
+	Payload struct {
+		Session      webSocketSession `json:"session"`
+		Subscription Subscription     `json:"subscription"`
+		Event        json.RawMessage  `json:"event"`
+	} `json:"payload"`
+}
+
+// A message or read error from a single connection.
+// Messages from every open connection are funneled into the same channel, since the old connection
+// is kept open until the new one is welcomed during a reconnect.
+type webSocketFrame struct {
+	conn    *websocket.Conn
+	message webSocketMessage
+	err     error
+}
+
+// WebSocketConfig is used to configure a new WebSocket connection
+type WebSocketConfig struct {
+	// User access token used to create subscriptions. Twitch requires a user access token for the WebSocket transport.
+	// If empty, the user access token the Client has for the user in the condition is used, and [UserTokenNotFoundError]
+	// is returned if there is none. See [WebSocket.AddSubscription].
+	UserToken string
+}
+
+// WebSocket is a connection to EventSub over the WebSocket transport.
+// Notifications received over the connection are dispatched to the handlers assigned with [Client.On].
+//
+// Create one using [Client.ConnectWebSocket].
+type WebSocket struct {
+	client    *Client
+	userToken string
+
+	sessionID string
+	keepalive time.Duration
+	conn      *websocket.Conn
+	frames    chan webSocketFrame
+
+	cancel    context.CancelFunc
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+// ConnectWebSocket connects to EventSub over the WebSocket transport and waits for the session welcome message.
+// Subscriptions for the session are created with [WebSocket.AddSubscription].
+//
+// The connection handles keepalive and reconnect messages on its own. If the connection is lost, Done is closed
+// and Err returns the reason. Subscriptions do not survive a lost connection, so you have to connect and subscribe again.
+//
+//	ws, err := client.ConnectWebSocket(ctx, twitchwh.WebSocketConfig{
+//		UserToken: "user access token",
+//	})
+//	if err != nil {
+//		log.Panic(err)
+//	}
+//	defer ws.Close()
+//	_, err = ws.AddSubscription(ctx, "channel.follow", "2", twitchwh.Condition{
+//		BroadcasterUserID: "215185844",
+//		ModeratorUserID:   "215185844",
+//	})
+func (c *Client) ConnectWebSocket(ctx context.Context, config WebSocketConfig) (*WebSocket, error) {
+	conn, session, err := c.dialWebSocket(ctx, c.webSocketURL)
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Info("WebSocket session welcomed", "session_id", session.ID)
+
+	runCtx, cancel := context.WithCancel(c.background)
+	ws := &WebSocket{
+		client:    c,
+		userToken: config.UserToken,
+		sessionID: session.ID,
+		keepalive: keepaliveTimeout(session),
+		conn:      conn,
+		frames:    make(chan webSocketFrame),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go ws.read(runCtx, conn)
+	go ws.run(runCtx)
+	return ws, nil
+}
+
+// Internal function that dials the URL and reads the welcome message.
+func (c *Client) dialWebSocket(ctx context.Context, url string) (*websocket.Conn, webSocketSession, error) {
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPClient: c.httpClient})
+	if err != nil {
+		return nil, webSocketSession{}, &InternalError{"Could not connect to WebSocket", err}
+	}
+
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		conn.CloseNow()
+		return nil, webSocketSession{}, &InternalError{"Could not read welcome message", err}
+	}
+	var message webSocketMessage
+	err = json.Unmarshal(data, &message)
+	if err != nil {
+		conn.CloseNow()
+		return nil, webSocketSession{}, &InternalError{"Could not parse welcome message", err}
+	}
+	if message.Metadata.MessageType != messageTypeWelcome {
+		conn.CloseNow()
+		return nil, webSocketSession{}, &UnexpectedMessageError{Expected: messageTypeWelcome, Got: message.Metadata.MessageType}
+	}
+	return conn, message.Payload.Session, nil
+}
+
+func keepaliveTimeout(session webSocketSession) time.Duration {
+	if session.KeepaliveTimeoutSeconds <= 0 {
+		// Twitch default
+		return 10 * time.Second
+	}
+	return time.Duration(session.KeepaliveTimeoutSeconds) * time.Second
+}
+
+// read forwards every message from conn to ws.frames until the connection fails.
+func (ws *WebSocket) read(ctx context.Context, conn *websocket.Conn) {
+	for {
+		var frame webSocketFrame
+		frame.conn = conn
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			frame.err = err
+		} else if err := json.Unmarshal(data, &frame.message); err != nil {
+			ws.client.logger.Warn("Could not parse WebSocket message", "session_id", ws.SessionID(), "error", err)
+			continue
+		}
+		select {
+		case ws.frames <- frame:
+		case <-ctx.Done():
+			return
+		}
+		if frame.err != nil {
+			return
+		}
+	}
+}
+
+// run handles incoming messages until the connection is closed or lost.
+func (ws *WebSocket) run(ctx context.Context) {
+	timer := time.NewTimer(ws.keepalive)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			ws.finish(nil)
+			return
+		case <-timer.C:
+			ws.finish(&KeepaliveTimeoutError{SessionID: ws.SessionID()})
+			return
+		case frame := <-ws.frames:
+			current := ws.currentConn()
+			if frame.err != nil {
+				if frame.conn != current {
+					// Old connection closed after a reconnect
+					continue
+				}
+				if ctx.Err() != nil {
+					ws.finish(nil)
+				} else {
+					ws.finish(&InternalError{"WebSocket connection lost", frame.err})
+				}
+				return
+			}
+			if frame.conn == current {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(ws.keepalive)
+			}
+			err := ws.handle(ctx, frame.message)
+			if err != nil {
+				ws.finish(err)
+				return
+			}
+		}
+	}
+}
+
+func (ws *WebSocket) handle(ctx context.Context, message webSocketMessage) error {
+	c := ws.client
+	logger := c.logger.With(
+		"session_id", ws.SessionID(),
+		"message_id", message.Metadata.MessageID,
+		"message_type", message.Metadata.MessageType,
+	)
+	switch message.Metadata.MessageType {
+	case messageTypeKeepalive:
+		return nil
+	case messageTypeNotification:
+		subscription := message.Payload.Subscription
+		logger = logger.With(subscriptionAttrs(subscription)...)
+		logger.Debug("Received event")
+		ctx, span := c.tracer.Start(ctx, "eventsub websocket",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attrSessionID.String(ws.SessionID()),
+				attrMessageID.String(message.Metadata.MessageID),
+				attrMessageType.String(message.Metadata.MessageType),
+			),
+			trace.WithAttributes(subscriptionSpanAttrs(subscription)...),
+		)
+		defer span.End()
+		err := c.dispatcher.reserve(ctx)
+		if errors.Is(err, errQueueFull) {
+			// Twitch does not retry WebSocket messages, so the event is lost
+			logger.Warn("Dropped event, dispatch queue is full")
+			c.metrics.EventDropped(subscription.Type)
+			c.reportError(&EventDroppedError{Type: subscription.Type, MessageID: message.Metadata.MessageID})
+			return nil
+		} else if err != nil {
+			return err
+		}
+		first, err := c.dedupStore.MarkHandled(ctx, message.Metadata.MessageID)
+		if err != nil {
+			// Twitch does not retry WebSocket messages, so dispatching twice is better than not at all
+			logger.Error("Could not mark event as handled", "error", err)
+			c.reportError(&InternalError{"Could not mark event as handled", err})
+		} else if !first {
+			c.dispatcher.release()
+			logger.Debug("Ignoring duplicate event")
+			c.metrics.DuplicateDelivery(subscription.Type)
+			return nil
+		}
+		c.dispatch(ctx, logger, Notification{
+			MessageID:    message.Metadata.MessageID,
+			Timestamp:    message.Metadata.MessageTimestamp,
+			Subscription: subscription,
+			Event:        message.Payload.Event,
+		})
+	case messageTypeRevocation:
+		c.revoke(logger, message.Payload.Subscription)
+	case messageTypeReconnect:
+		logger.Info("WebSocket session is reconnecting")
+		return ws.reconnect(ctx, message.Payload.Session.ReconnectURL)
+	default:
+		logger.Warn("Unknown WebSocket message type")
+	}
+	return nil
+}
+
+// reconnect connects to the reconnect URL and closes the old connection once the new one is welcomed.
+// Subscriptions are carried over to the new connection by Twitch.
+func (ws *WebSocket) reconnect(ctx context.Context, url string) error {
+	conn, session, err := ws.client.dialWebSocket(ctx, url)
+	if err != nil {
+		return err
+	}
+	ws.mu.Lock()
+	old := ws.conn
+	ws.conn = conn
+	ws.sessionID = session.ID
+	ws.keepalive = keepaliveTimeout(session)
+	ws.mu.Unlock()
+
+	go ws.read(ctx, conn)
+	old.Close(websocket.StatusNormalClosure, "")
+	ws.client.logger.Info("WebSocket session reconnected", "session_id", session.ID)
+	return nil
+}
+
+func (ws *WebSocket) currentConn() *websocket.Conn {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.conn
+}
+
+func (ws *WebSocket) finish(err error) {
+	ws.closeOnce.Do(func() {
+		ws.err = err
+		ws.cancel()
+		conn := ws.currentConn()
+		if err != nil {
+			ws.client.logger.Warn("WebSocket session ended", "session_id", ws.SessionID(), "error", err)
+			conn.CloseNow()
+		} else {
+			conn.Close(websocket.StatusNormalClosure, "")
+		}
+		close(ws.done)
+	})
+}
+
+// SessionID returns the ID of the current WebSocket session.
+func (ws *WebSocket) SessionID() string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.sessionID
+}
+
+// Done is closed when the connection is closed or lost.
+func (ws *WebSocket) Done() <-chan struct{} {
+	return ws.done
+}
+
+// Err blocks until Done is closed, then returns the reason the connection was lost.
+// Returns nil if the connection was closed with Close.
+func (ws *WebSocket) Err() error {
+	<-ws.done
+	return ws.err
+}
+
+// Close closes the connection. All subscriptions for the session are removed by Twitch.
+func (ws *WebSocket) Close() error {
+	ws.cancel()
+	<-ws.done
+	return nil
+}
+
+// AddSubscription attempts to create a new subscription for this WebSocket session based on the type, version, and condition.
+// You can find all subscription types, versions, and conditions at: [EventSub subscription types].
+//
+// Unlike [Client.AddSubscription], this does not wait for verification since Twitch does not verify WebSocket subscriptions.
+// Returns the subscription created by Helix.
+//
+// The subscription is created with WebSocketConfig.UserToken if set. Otherwise, the user access token of the
+// user that must authorize the subscription type is used (see [Client.SetUserToken]), and refreshed if Helix rejects it.
+// Twitch does not accept app access tokens for WebSocket subscriptions, so [UserTokenNotFoundError] is returned
+// if the client has no token for the user. Returns [MissingScopeError] if the user has not granted the required scopes.
+//
+// [EventSub subscription types]: https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/
+func (ws *WebSocket) AddSubscription(ctx context.Context, Type string, version string, condition Condition) (subscription Subscription, err error) {
+	if ws.userToken != "" {
+		return ws.addSubscription(ctx, ws.userToken, Type, version, condition)
+	}
+	userID := ws.client.authorizingUser(Type, condition)
+	if userID == "" {
+		// Name the user the token is most likely missing for, in the same order as authorizingUser
+		for _, id := range []string{condition.ModeratorUserID, condition.UserID, condition.BroadcasterUserID} {
+			if id != "" {
+				return Subscription{}, &UserTokenNotFoundError{UserID: id}
+			}
+		}
+		return Subscription{}, &UserTokenNotFoundError{}
+	}
+	err = ws.client.retryUserUnauthorized(ctx, userID, func(token string) error {
+		subscription, err = ws.addSubscription(ctx, token, Type, version, condition)
+		return err
+	})
+	return subscription, err
+}
+
+func (ws *WebSocket) addSubscription(ctx context.Context, token string, Type string, version string, condition Condition) (Subscription, error) {
+	subscription, err := ws.client.createSubscription(ctx, token, Type, version, condition, transport{
+		Method:    "websocket",
+		SessionID: ws.SessionID(),
+	})
+	if err != nil {
+		return Subscription{}, err
+	}
+	ws.client.logger.Info("Subscription created", subscriptionAttrs(subscription)...)
+	return subscription, nil
+}
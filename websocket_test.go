@@ -0,0 +1,180 @@
+package twitchwh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func newWebSocketTestClient(url string) *Client {
+	c := newTestClient()
+	c.webSocketURL = url
+	return c
+}
+
+func webSocketTestServer(t *testing.T, serve func(ctx context.Context, conn *websocket.Conn, r *http.Request)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("Could not accept WebSocket: %s", err)
+			return
+		}
+		defer conn.CloseNow()
+		serve(r.Context(), conn, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeMessage(ctx context.Context, conn *websocket.Conn, message string) {
+	conn.Write(ctx, websocket.MessageText, []byte(message))
+}
+
+func welcomeMessage(sessionID string, keepalive int) string {
+	return fmt.Sprintf(`{"metadata":{"message_id":"%s-welcome","message_type":"session_welcome"},"payload":{"session":{"id":"%s","status":"connected","keepalive_timeout_seconds":%d}}}`, sessionID, sessionID, keepalive)
+}
+
+func notificationMessage(messageID string) string {
+	return fmt.Sprintf(`{"metadata":{"message_id":"%s","message_type":"notification"},"payload":{"subscription":{"id":"sub","type":"stream.online","version":"1"},"event":{"broadcaster_user_login":"linneb"}}}`, messageID)
+}
+
+func TestWebSocketNotification(t *testing.T) {
+	server := webSocketTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		writeMessage(ctx, conn, welcomeMessage("session", 10))
+		writeMessage(ctx, conn, notificationMessage("1"))
+		// Duplicate should be ignored
+		writeMessage(ctx, conn, notificationMessage("1"))
+		writeMessage(ctx, conn, notificationMessage("2"))
+		conn.Read(ctx)
+	})
+
+	c := newWebSocketTestClient("ws" + strings.TrimPrefix(server.URL, "http"))
+	events := make(chan json.RawMessage, 3)
+	c.On("stream.online", func(event json.RawMessage) {
+		events <- event
+	})
+
+	ws, err := c.ConnectWebSocket(context.Background(), WebSocketConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if ws.SessionID() != "session" {
+		t.Fatalf("Expected session ID session, got %s", ws.SessionID())
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for event")
+		}
+	}
+	select {
+	case <-events:
+		t.Fatal("Duplicate event was dispatched")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebSocketReconnect(t *testing.T) {
+	reconnected := make(chan struct{})
+	server := webSocketTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		if r.URL.Query().Has("reconnect") {
+			writeMessage(ctx, conn, welcomeMessage("new-session", 10))
+			writeMessage(ctx, conn, notificationMessage("1"))
+			close(reconnected)
+			conn.Read(ctx)
+			return
+		}
+		writeMessage(ctx, conn, welcomeMessage("old-session", 10))
+		url := "ws" + strings.TrimPrefix("http://"+r.Host, "http") + "/?reconnect=1"
+		writeMessage(ctx, conn, fmt.Sprintf(`{"metadata":{"message_id":"reconnect","message_type":"session_reconnect"},"payload":{"session":{"id":"old-session","status":"reconnecting","reconnect_url":"%s"}}}`, url))
+		conn.Read(ctx)
+	})
+
+	c := newWebSocketTestClient("ws" + strings.TrimPrefix(server.URL, "http"))
+	events := make(chan json.RawMessage, 1)
+	c.On("stream.online", func(event json.RawMessage) {
+		events <- event
+	})
+
+	ws, err := c.ConnectWebSocket(context.Background(), WebSocketConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event on new connection")
+	}
+	<-reconnected
+	if ws.SessionID() != "new-session" {
+		t.Fatalf("Expected session ID new-session, got %s", ws.SessionID())
+	}
+}
+
+func TestWebSocketKeepaliveTimeout(t *testing.T) {
+	server := webSocketTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		writeMessage(ctx, conn, welcomeMessage("session", 1))
+		conn.Read(ctx)
+	})
+
+	c := newWebSocketTestClient("ws" + strings.TrimPrefix(server.URL, "http"))
+	ws, err := c.ConnectWebSocket(context.Background(), WebSocketConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ws.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("Connection was not closed after keepalive timeout")
+	}
+	var timeoutErr *KeepaliveTimeoutError
+	if !errors.As(ws.Err(), &timeoutErr) {
+		t.Fatalf("Expected KeepaliveTimeoutError, got %v", ws.Err())
+	}
+}
+
+func TestWebSocketAddSubscriptionWithoutUserToken(t *testing.T) {
+	server := webSocketTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		writeMessage(ctx, conn, welcomeMessage("session", 10))
+		conn.Read(ctx)
+	})
+	helix := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected Helix request %s %s", r.Method, r.URL)
+		w.WriteHeader(500)
+	}))
+	defer helix.Close()
+
+	c := newWebSocketTestClient("ws" + strings.TrimPrefix(server.URL, "http"))
+	c.helixURL = helix.URL
+	ws, err := c.ConnectWebSocket(context.Background(), WebSocketConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// channel.subscribe has a known requirement, stream.online falls back to the users in the condition
+	for _, Type := range []string{"channel.subscribe", "stream.online"} {
+		var notFoundErr *UserTokenNotFoundError
+		_, err = ws.AddSubscription(context.Background(), Type, "1", Condition{BroadcasterUserID: "1"})
+		if !errors.As(err, &notFoundErr) || notFoundErr.UserID != "1" {
+			t.Fatalf("Expected UserTokenNotFoundError for user 1 creating %s, got %v", Type, err)
+		}
+	}
+	if quota := c.Quota(); quota != (SubscriptionQuota{}) {
+		t.Fatalf("Quota was updated without a request: %+v", quota)
+	}
+}